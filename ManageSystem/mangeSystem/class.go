@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// classInfo 班级，学生通过Class字段记录班级名称
+type classInfo struct {
+	Name        string    `json:"name"`
+	HeadTeacher string    `json:"headTeacher"`
+	Archived    bool      `json:"archived"` //已归档的班级保留学生，但不能再转入学生
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// classUpdate 修改班级的请求，只修改非空的字段
+type classUpdate struct {
+	Name        string `json:"name"` //新名称，学生的班级随之修改
+	HeadTeacher string `json:"headTeacher"`
+	Archived    *bool  `json:"archived"`
+}
+
+var (
+	errUnknownClass    = errors.New("班级不存在")
+	errClassArchived   = errors.New("班级已归档")
+	errStudentNotFound = errors.New("学生不存在")
+)
+
+// 班级注册表，为空时不校验学生的班级，与引入班级之前的行为一致
+// 加锁顺序为先mu后classesMu，持有classesMu时不能再获取mu
+var (
+	classesMu sync.RWMutex
+	classes   = make(map[string]*classInfo) //班级名称到班级
+	classFile string                        //班级保存的文件，为空时只保存在内存中
+)
+
+// loadClasses 从JSON文件读取班级，文件不存在时从空注册表开始，之后的修改都会写回该文件
+func loadClasses(path string) error {
+	classFile = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*classInfo
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("班级文件损坏：%v", err)
+	}
+	classesMu.Lock()
+	defer classesMu.Unlock()
+	classes = make(map[string]*classInfo)
+	for _, info := range list {
+		classes[info.Name] = info
+	}
+	return nil
+}
+
+// saveClasses 把班级写回文件，先写临时文件再改名，调用方需持有classesMu
+func saveClasses() error {
+	if classFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(sortedClasses(true), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := classFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, classFile)
+}
+
+func sortedClasses(archived bool) []*classInfo {
+	list := make([]*classInfo, 0, len(classes))
+	for _, info := range classes {
+		if archived || !info.Archived {
+			list = append(list, info)
+		}
+	}
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].Name < list[b].Name
+	})
+	return list
+}
+
+// checkClass 检查学生可以加入该班级，没有创建任何班级或班级为空时不校验
+func checkClass(name string) error {
+	classesMu.RLock()
+	defer classesMu.RUnlock()
+	if len(classes) == 0 || name == "" {
+		return nil
+	}
+	info, ok := classes[name]
+	if !ok {
+		return fmt.Errorf("%w：%s", errUnknownClass, name)
+	}
+	if info.Archived {
+		return fmt.Errorf("%w：%s", errClassArchived, name)
+	}
+	return nil
+}
+
+// checkClassKnown 只检查班级已创建，不检查是否归档
+func checkClassKnown(name string) error {
+	classesMu.RLock()
+	defer classesMu.RUnlock()
+	if len(classes) == 0 || name == "" {
+		return nil
+	}
+	if _, ok := classes[name]; !ok {
+		return fmt.Errorf("%w：%s", errUnknownClass, name)
+	}
+	return nil
+}
+
+// checkClassChange 学生从oldClass转到newClass时检查目标班级，班级不变时不检查，归档班级中的学生仍可修改其他信息
+func checkClassChange(oldClass, newClass string) error {
+	if newClass == oldClass {
+		return nil
+	}
+	return checkClass(newClass)
+}
+
+// moveClass 把numbers中的学生转到班级to，全部成功或全部失败，调用方需持有mu
+func moveClass(numbers []string, to string) error {
+	moved := make([]*student, 0, len(numbers))
+	for _, number := range numbers {
+		stu, exists, err := store.Get(number)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w：%s", errStudentNotFound, number)
+		}
+		stu.Class = to
+		moved = append(moved, stu)
+	}
+	return store.PutAll(moved)
+}
+
+// classNumbers 返回班级全部学生的学号，调用方需持有mu
+func classNumbers(name string) ([]string, error) {
+	var numbers []string
+	err := rangeIndexed(name, nil, func(stu *student) bool {
+		if stu.Class == name {
+			numbers = append(numbers, stu.Number)
+		}
+		return true
+	})
+	return numbers, err
+}
+
+func classStatus(err error) int {
+	switch {
+	case errors.Is(err, errUnknownClass):
+		return http.StatusNotFound
+	case errors.Is(err, errClassArchived):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func addClass(c *gin.Context) {
+	var info classInfo
+	if err := c.ShouldBindJSON(&info); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	info.Name = strings.TrimSpace(info.Name)
+	if info.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "班级名称不能为空"})
+		return
+	}
+	info.Archived = false
+	info.CreatedAt = time.Now()
+	classesMu.Lock()
+	defer classesMu.Unlock()
+	if _, exists := classes[info.Name]; exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "班级已存在"})
+		return
+	}
+	classes[info.Name] = &info
+	if err := saveClasses(); err != nil {
+		delete(classes, info.Name)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": &info})
+}
+
+// updateClass 修改班级的名称、班主任或归档状态，改名时该班全部学生的班级一并修改
+func updateClass(c *gin.Context) {
+	name := c.Query("name")
+	var update classUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	update.Name = strings.TrimSpace(update.Name)
+	mu.Lock()
+	defer mu.Unlock()
+	classesMu.Lock()
+	defer classesMu.Unlock()
+	old, exists := classes[name]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": errUnknownClass.Error()})
+		return
+	}
+	info := *old
+	if update.HeadTeacher != "" {
+		info.HeadTeacher = update.HeadTeacher
+	}
+	if update.Archived != nil {
+		info.Archived = *update.Archived
+	}
+	if update.Name != "" && update.Name != name {
+		if _, dup := classes[update.Name]; dup {
+			c.JSON(http.StatusConflict, gin.H{"error": "班级已存在"})
+			return
+		}
+		info.Name = update.Name
+	}
+	delete(classes, name)
+	classes[info.Name] = &info
+	if err := saveClasses(); err != nil {
+		delete(classes, info.Name)
+		classes[name] = old
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if info.Name != name {
+		numbers, err := classNumbers(name)
+		if err == nil {
+			err = moveClass(numbers, info.Name)
+		}
+		if err != nil {
+			delete(classes, info.Name)
+			classes[name] = old
+			_ = saveClasses()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": &info})
+}
+
+// listClasses 列出班级，archived=true时包含已归档的班级
+func listClasses(c *gin.Context) {
+	archived := c.Query("archived") == "true"
+	classesMu.RLock()
+	defer classesMu.RUnlock()
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": sortedClasses(archived)})
+}
+
+// getRoster 查询班级信息和按学号排序的学生名单
+func getRoster(c *gin.Context) {
+	name := c.Query("name")
+	mu.Lock()
+	defer mu.Unlock()
+	classesMu.RLock()
+	info, exists := classes[name]
+	classesMu.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": errUnknownClass.Error()})
+		return
+	}
+	roster := []*student{}
+	err := rangeIndexed(name, nil, func(stu *student) bool {
+		if stu.Class == name {
+			roster = append(roster, stu)
+		}
+		return true
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": gin.H{"class": info, "students": roster}})
+}
+
+// moveStudents 把请求体中列出学号的学生转到班级to，任一学生不存在时都不转
+func moveStudents(c *gin.Context) {
+	var numbers []string
+	if err := c.ShouldBindJSON(&numbers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to := c.Query("to")
+	mu.Lock()
+	defer mu.Unlock()
+	//持有mu后再检查目标班级，班级的修改也需要mu，检查之后不会被归档或改名
+	//目标班级必须已创建且未归档，没有创建任何班级时也不能转班
+	classesMu.RLock()
+	info, exists := classes[to]
+	classesMu.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%v：%s", errUnknownClass, to)})
+		return
+	}
+	if info.Archived {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%v：%s", errClassArchived, to)})
+		return
+	}
+	if err := moveClass(numbers, to); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errStudentNotFound) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": ""})
+}
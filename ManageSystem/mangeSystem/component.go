@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// componentSep 考核项成绩的键为“课程名.考核项”，如 数学.期中，与课程总评保存在同一个成绩表中
+const componentSep = "."
+
+// component 课程的一个考核项，Weight为百分比，一门课程全部考核项的权重之和为100
+type component struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+}
+
+// splitScoreKey 拆分成绩的键，不是考核项时component为空
+func splitScoreKey(key string) (subject, component string) {
+	if i := strings.LastIndex(key, componentSep); i > 0 && i < len(key)-len(componentSep) {
+		return key[:i], key[i+len(componentSep):]
+	}
+	return key, ""
+}
+
+// isComponentKey 判断成绩的键是否为已注册课程的考核项，统计总分等只使用课程总评
+// 名称中带分隔符的课程（如 Python3.0）和未注册的课程不是考核项
+func isComponentKey(key string) bool {
+	if !strings.Contains(key, componentSep) {
+		return false
+	}
+	coursesMu.RLock()
+	defer coursesMu.RUnlock()
+	return isComponentKeyLocked(key)
+}
+
+// isComponentKeyLocked 与isComponentKey相同，调用方需持有coursesMu
+func isComponentKeyLocked(key string) bool {
+	subject, compName := splitScoreKey(key)
+	if compName == "" {
+		return false
+	}
+	if _, ok := courseLookup[courseKey(key)]; ok {
+		return false
+	}
+	code, ok := courseLookup[courseKey(subject)]
+	if !ok {
+		return false
+	}
+	_, ok = courses[code].component(compName)
+	return ok
+}
+
+var errComponentHasScores = errors.New("考核项已有学生成绩，不能删除")
+
+// checkRemovedComponents 修改课程时不能删除已有成绩的考核项，否则会留下无法计算的考核项成绩和过期的总评，调用方需持有mu
+func checkRemovedComponents(old, updated *course) error {
+	removed := removedComponents(old, updated)
+	if len(removed) == 0 {
+		return nil
+	}
+	var used string
+	err := store.Range(func(stu *student) bool {
+		for _, scores := range append([]map[string]grade{stu.Scores}, termMaps(stu)...) {
+			for _, name := range removed {
+				if _, ok := scores[old.Name+componentSep+name]; ok {
+					used = name
+					return false
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if used != "" {
+		return fmt.Errorf("%w：%v", errComponentHasScores, used)
+	}
+	return nil
+}
+
+// removedComponents 返回old中有而updated中没有的考核项名称
+func removedComponents(old, updated *course) []string {
+	var removed []string
+	for _, comp := range old.Components {
+		if _, ok := updated.component(comp.Name); !ok {
+			removed = append(removed, comp.Name)
+		}
+	}
+	return removed
+}
+
+// validateComponents 检查考核项名称不重复、权重为正且合计为100
+func (c *course) validateComponents() error {
+	if len(c.Components) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	total := 0.0
+	for i := range c.Components {
+		comp := &c.Components[i]
+		comp.Name = strings.TrimSpace(comp.Name)
+		if comp.Name == "" || strings.Contains(comp.Name, componentSep) {
+			return fmt.Errorf("考核项名称不能为空或包含%q", componentSep)
+		}
+		if seen[courseKey(comp.Name)] {
+			return fmt.Errorf("考核项%v重复", comp.Name)
+		}
+		seen[courseKey(comp.Name)] = true
+		if comp.Weight <= 0 {
+			return fmt.Errorf("考核项%v的权重必须大于0", comp.Name)
+		}
+		total += comp.Weight
+	}
+	if math.Abs(total-100) > 1e-6 {
+		return fmt.Errorf("考核项权重合计应为100，实际为%v", total)
+	}
+	return nil
+}
+
+// component 按名称查找考核项，忽略大小写和多余的空白
+func (c *course) component(name string) (string, bool) {
+	for _, comp := range c.Components {
+		if courseKey(comp.Name) == courseKey(name) {
+			return comp.Name, true
+		}
+	}
+	return "", false
+}
+
+// canonicalScoreKey 把成绩的键转换为标准写法，考核项的课程和考核项名称都转换为注册表中的名称
+// 没有注册任何课程时原样返回，不识别考核项
+func canonicalScoreKey(key string) (string, *course, bool, error) {
+	subject, compName := splitScoreKey(key)
+	//整个键是课程名称或没有注册课程时，按课程处理
+	name, c, err := canonicalSubject(key)
+	if err == nil || compName == "" {
+		return name, c, false, err
+	}
+	name, c, err = canonicalSubject(subject)
+	if err != nil {
+		return "", nil, false, err
+	}
+	canonical, ok := c.component(compName)
+	if !ok {
+		return "", nil, false, fmt.Errorf("%w：%v没有考核项%v", errUnknownCourse, c.Name, compName)
+	}
+	return name + componentSep + canonical, c, true, nil
+}
+
+// componentScoreKey 返回指定考核项成绩的标准键，课程必须已注册且有该考核项
+// 否则 数学.期中 会被当作一门独立的课程保存，计入总分和绩点
+func componentScoreKey(subject, compName string) (string, error) {
+	coursesMu.RLock()
+	defer coursesMu.RUnlock()
+	code, ok := courseLookup[courseKey(subject)]
+	if !ok {
+		return "", fmt.Errorf("%w：%s", errUnknownCourse, subject)
+	}
+	c := courses[code]
+	canonical, ok := c.component(compName)
+	if !ok {
+		return "", fmt.Errorf("%w：%v没有考核项%v", errUnknownCourse, c.Name, compName)
+	}
+	return c.Name + componentSep + canonical, nil
+}
+
+var errComponentGrade = errors.New("考核项成绩只能是数值或缺考、免考、作弊")
+
+// checkScoreKey 检查成绩的范围，考核项使用与课程相同的满分，且不能是等级或合格制成绩
+func checkScoreKey(c *course, isComponent bool, g grade) error {
+	if isComponent && g.Mark != "" && g.Mark != markAbsent && g.Mark != markExempt && g.Mark != markCheating {
+		return errComponentGrade
+	}
+	return checkScore(c, g)
+}
+
+// computeTotals 根据考核项计算课程总评，需在考核项成绩变化后调用
+// 考核项齐全时按权重计算总评，缺考和作弊计0分，免考的考核项不计入，其余考核项按权重折算；
+// 有考核项但不齐全时删除总评，避免保留过期的结果；没有任何考核项成绩时保留直接录入的总评
+func computeTotals(scores map[string]grade) {
+	if len(scores) == 0 {
+		return
+	}
+	coursesMu.RLock()
+	defer coursesMu.RUnlock()
+	computeTotalsLocked(scores)
+}
+
+// computeTotalsLocked 与computeTotals相同，调用方需持有coursesMu
+func computeTotalsLocked(scores map[string]grade) {
+	for _, c := range courses {
+		if len(c.Components) == 0 {
+			continue
+		}
+		present, complete := false, true
+		total, weights := 0.0, 0.0
+		for _, comp := range c.Components {
+			g, ok := scores[c.Name+componentSep+comp.Name]
+			if !ok {
+				complete = false
+				continue
+			}
+			present = true
+			switch g.Mark {
+			case "":
+				total += g.Score * comp.Weight
+				weights += comp.Weight
+			case markAbsent, markCheating:
+				weights += comp.Weight
+			}
+		}
+		switch {
+		case !present:
+		case !complete:
+			delete(scores, c.Name)
+		case weights == 0:
+			scores[c.Name] = grade{Mark: markExempt}
+		default:
+			scores[c.Name] = numGrade(math.Round(total/weights*100) / 100)
+		}
+	}
+}
+
+var errComputedTotal = errors.New("已有考核项成绩的课程总评由考核项计算，不能直接录入或删除")
+
+// checkComputedTotals 检查keys中的课程总评是否由考核项计算，这样的总评直接录入或删除后会被computeTotals重新计算覆盖
+// scores为修改之后的成绩，同时删除总评和全部考核项成绩时可以删除
+func checkComputedTotals(scores map[string]grade, keys []string) error {
+	coursesMu.RLock()
+	defer coursesMu.RUnlock()
+	for _, key := range keys {
+		code, ok := courseLookup[courseKey(key)]
+		if !ok || courses[code].Name != key {
+			continue
+		}
+		c := courses[code]
+		for _, comp := range c.Components {
+			if _, ok := scores[c.Name+componentSep+comp.Name]; ok {
+				return fmt.Errorf("%w：%v", errComputedTotal, c.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// recomputeCourseTotals 课程的考核项或权重修改后，重新计算所有学生各学期该课程的总评
+// 调用方需持有mu和coursesMu
+func recomputeCourseTotals(name string) error {
+	var changed []*student
+	err := store.Range(func(stu *student) bool {
+		if !hasSubject(stu, name) {
+			return true
+		}
+		stu = stu.clone()
+		for _, scores := range append([]map[string]grade{stu.Scores}, termMaps(stu)...) {
+			computeTotalsLocked(scores)
+		}
+		changed = append(changed, stu)
+		return true
+	})
+	if err != nil || len(changed) == 0 {
+		return err
+	}
+	return store.PutAll(changed)
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// course 课程，学生成绩以课程名称为键保存
+type course struct {
+	Code       string      `json:"code"`
+	Name       string      `json:"name"`
+	Credits    float64     `json:"credits"`
+	MaxScore   int         `json:"maxScore"` //满分，为0时默认100
+	PassLine   int         `json:"passLine"` //及格线，为0时默认满分的60%
+	Aliases    []string    `json:"aliases,omitempty"`
+	Components []component `json:"components,omitempty"` //考核项及权重，有考核项时课程总评由考核项计算
+}
+
+var (
+	errUnknownCourse   = errors.New("未知的课程")
+	errCourseConflict  = errors.New("课程名称或别名已被使用")
+	errCourseHasScores = errors.New("该课程已有学生成绩，不能删除")
+)
+
+// 课程注册表，为空时不校验课程名称，与引入课程之前的行为一致
+// 加锁顺序为先mu后coursesMu，持有coursesMu时不能再获取mu
+var (
+	coursesMu    sync.RWMutex
+	courses      = make(map[string]*course) //课程代码到课程
+	courseLookup = make(map[string]string)  //规范化后的代码、名称和别名到课程代码
+	courseFile   string                     //课程保存的文件，为空时只保存在内存中
+)
+
+// courseKey 规范化课程名称，忽略大小写和多余的空白，如 "Math " 与 "math" 相同
+func courseKey(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// loadCourses 从JSON文件读取课程，文件不存在时从空注册表开始，之后的修改都会写回该文件
+func loadCourses(path string) error {
+	courseFile = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*course
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("课程文件损坏：%v", err)
+	}
+	coursesMu.Lock()
+	defer coursesMu.Unlock()
+	courses = make(map[string]*course)
+	for _, c := range list {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("课程%v：%v", c.Code, err)
+		}
+		courses[c.Code] = c
+	}
+	return rebuildCourseLookup()
+}
+
+// saveCourses 把课程写回文件，先写临时文件再改名，调用方需持有coursesMu
+func saveCourses() error {
+	if courseFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(sortedCourses(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := courseFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, courseFile)
+}
+
+// rebuildCourseLookup 重建名称查找表，代码、名称或别名冲突时返回错误，调用方需持有coursesMu
+func rebuildCourseLookup() error {
+	lookup := make(map[string]string)
+	for _, c := range courses {
+		for _, name := range append([]string{c.Code, c.Name}, c.Aliases...) {
+			key := courseKey(name)
+			if code, ok := lookup[key]; ok && code != c.Code {
+				return fmt.Errorf("%w：%s", errCourseConflict, name)
+			}
+			lookup[key] = c.Code
+		}
+	}
+	courseLookup = lookup
+	return nil
+}
+
+func sortedCourses() []*course {
+	list := make([]*course, 0, len(courses))
+	for _, c := range courses {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].Code < list[b].Code
+	})
+	return list
+}
+
+// canonicalSubject 把课程代码、名称或别名转换为课程名称
+// 没有注册任何课程时原样返回，c为nil
+func canonicalSubject(subject string) (string, *course, error) {
+	coursesMu.RLock()
+	defer coursesMu.RUnlock()
+	if len(courses) == 0 {
+		return subject, nil, nil
+	}
+	code, ok := courseLookup[courseKey(subject)]
+	if !ok {
+		return "", nil, fmt.Errorf("%w：%s", errUnknownCourse, subject)
+	}
+	c := courses[code]
+	return c.Name, c, nil
+}
+
+// checkScore 检查数值成绩在0到满分之间，等级和特殊成绩不受满分限制
+func checkScore(c *course, g grade) error {
+	score, ok := g.numeric()
+	if ok && !finite(score) {
+		return fmt.Errorf("无法识别的成绩：%v", score)
+	}
+	if c != nil && ok && (score < 0 || score > float64(c.MaxScore)) {
+		return fmt.Errorf("%v成绩必须在0到%v之间", c.Name, c.MaxScore)
+	}
+	return nil
+}
+
+// normalizeScores 把成绩的课程和考核项转换为标准写法并检查分数范围，两个键指向同一课程或考核项时返回错误
+func normalizeScores(scores map[string]grade) (map[string]grade, error) {
+	if scores == nil {
+		return nil, nil
+	}
+	normalized := make(map[string]grade, len(scores))
+	for subject, score := range scores {
+		name, c, isComponent, err := canonicalScoreKey(subject)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := normalized[name]; dup {
+			return nil, fmt.Errorf("课程%v重复", name)
+		}
+		if err := checkScoreKey(c, isComponent, score); err != nil {
+			return nil, err
+		}
+		normalized[name] = score
+	}
+	return normalized, nil
+}
+
+// UnmarshalJSON 没有填写credits时与未注册的课程一样按默认学分计算，注册课程不会改变绩点
+// 明确填写0表示不计学分的课程，如体育、班会
+func (c *course) UnmarshalJSON(data []byte) error {
+	type plain course
+	p := plain{Credits: defaultCredits}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*c = course(p)
+	return nil
+}
+
+// validate 检查课程并填充默认值
+func (c *course) validate() error {
+	c.Code = strings.TrimSpace(c.Code)
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Code == "" || c.Name == "" {
+		return errors.New("课程代码和名称不能为空")
+	}
+	if c.Credits < 0 {
+		return errors.New("学分不能为负数")
+	}
+	if c.MaxScore == 0 {
+		c.MaxScore = 100
+	}
+	if c.PassLine == 0 {
+		c.PassLine = c.MaxScore * 6 / 10
+	}
+	if c.MaxScore < 0 || c.PassLine < 0 || c.PassLine > c.MaxScore {
+		return errors.New("及格线必须在0到满分之间")
+	}
+	return c.validateComponents()
+}
+
+// putCourse 新增或替换课程，冲突或保存失败时恢复原来的注册表，调用方需持有coursesMu
+func putCourse(c *course) error {
+	old, existed := courses[c.Code]
+	courses[c.Code] = c
+	err := rebuildCourseLookup()
+	if err == nil {
+		err = saveCourses()
+	}
+	if err != nil {
+		if existed {
+			courses[c.Code] = old
+		} else {
+			delete(courses, c.Code)
+		}
+		_ = rebuildCourseLookup()
+	}
+	return err
+}
+
+// renameScoreKey 把old课程的成绩或考核项成绩的键改为newName下的键，其他键原样返回
+func renameScoreKey(key string, old *course, newName string) string {
+	if key == old.Name {
+		return newName
+	}
+	if subject, compName := splitScoreKey(key); compName != "" && subject == old.Name {
+		if canonical, ok := old.component(compName); ok {
+			return newName + componentSep + canonical
+		}
+	}
+	return key
+}
+
+// canonicalKeyLocked 把成绩的键转换为注册表中的写法，不属于已注册课程的键原样返回，调用方需持有coursesMu
+func canonicalKeyLocked(key string) string {
+	if code, ok := courseLookup[courseKey(key)]; ok {
+		return courses[code].Name
+	}
+	subject, compName := splitScoreKey(key)
+	if compName == "" {
+		return key
+	}
+	code, ok := courseLookup[courseKey(subject)]
+	if !ok {
+		return key
+	}
+	c := courses[code]
+	if name, ok := c.component(compName); ok {
+		return c.Name + componentSep + name
+	}
+	return key
+}
+
+// rewriteScoreKeys 按rename修改所有学生各学期成绩的键，调用方需持有mu
+// 两个键改写后相同时返回冲突错误，不修改任何学生
+func rewriteScoreKeys(rename func(key string) string) error {
+	var changed []*student
+	var conflict error
+	err := store.Range(func(stu *student) bool {
+		stu = stu.clone()
+		modified := false
+		for _, scores := range append([]map[string]grade{stu.Scores}, termMaps(stu)...) {
+			renamed := make(map[string]grade, len(scores))
+			for key, score := range scores {
+				target := rename(key)
+				if _, dup := renamed[target]; dup {
+					conflict = fmt.Errorf("%w：学号%v有多个成绩对应%v", errCourseConflict, stu.Number, target)
+					return false
+				}
+				renamed[target] = score
+				modified = modified || target != key
+			}
+			//原地替换，Scores和Terms中的map都指向克隆出的副本
+			for key := range scores {
+				delete(scores, key)
+			}
+			for key, score := range renamed {
+				scores[key] = score
+			}
+		}
+		if modified {
+			changed = append(changed, stu)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if conflict != nil {
+		return conflict
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return store.PutAll(changed)
+}
+
+// migrateScoreKeys 启动时把已有成绩中的课程代码、别名等写法统一为注册表中的课程名称
+// 注册表之前录入的成绩与之后录入的一致，未注册的课程保持不变
+func migrateScoreKeys() error {
+	mu.Lock()
+	defer mu.Unlock()
+	coursesMu.RLock()
+	defer coursesMu.RUnlock()
+	if len(courses) == 0 {
+		return nil
+	}
+	return rewriteScoreKeys(canonicalKeyLocked)
+}
+
+// hasSubject 判断学生在任一学期是否有该课程的成绩或考核项成绩
+func hasSubject(stu *student, subject string) bool {
+	for _, scores := range append([]map[string]grade{stu.Scores}, termMaps(stu)...) {
+		for key := range scores {
+			if name, _ := splitScoreKey(key); key == subject || name == subject {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func termMaps(stu *student) []map[string]grade {
+	maps := make([]map[string]grade, 0, len(stu.Terms))
+	for _, scores := range stu.Terms {
+		maps = append(maps, scores)
+	}
+	return maps
+}
+
+func courseStatus(err error) int {
+	switch {
+	case errors.Is(err, errCourseConflict), errors.Is(err, errCourseHasScores), errors.Is(err, errComponentHasScores):
+		return http.StatusConflict
+	case errors.Is(err, errUnknownCourse):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func addCourse(c *gin.Context) {
+	var newCourse course
+	if err := c.ShouldBindJSON(&newCourse); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := newCourse.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	coursesMu.Lock()
+	defer coursesMu.Unlock()
+	if _, exists := courses[newCourse.Code]; exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "课程代码已存在"})
+		return
+	}
+	if err := putCourse(&newCourse); err != nil {
+		c.JSON(courseStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	//已有成绩中该课程的其他写法统一为课程名称
+	if err := rewriteScoreKeys(canonicalKeyLocked); err != nil {
+		delete(courses, newCourse.Code)
+		_ = rebuildCourseLookup()
+		_ = saveCourses()
+		c.JSON(courseStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": &newCourse})
+}
+
+// updateCourse 根据课程代码修改课程，修改名称时学生的成绩一并改到新名称下
+func updateCourse(c *gin.Context) {
+	var updated course
+	if err := c.ShouldBindJSON(&updated); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updated.Code = c.Query("code")
+	if err := updated.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	coursesMu.Lock()
+	defer coursesMu.Unlock()
+	old, exists := courses[updated.Code]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "课程不存在"})
+		return
+	}
+	if err := checkRemovedComponents(old, &updated); err != nil {
+		c.JSON(courseStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := putCourse(&updated); err != nil {
+		c.JSON(courseStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	//改名时成绩迁移到新名称下，新的别名对应的已有成绩也统一为课程名称，新名称已被其他成绩使用时拒绝修改
+	err := rewriteScoreKeys(func(key string) string {
+		return canonicalKeyLocked(renameScoreKey(key, old, updated.Name))
+	})
+	if err != nil {
+		_ = putCourse(old)
+		c.JSON(courseStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if len(old.Components) > 0 || len(updated.Components) > 0 {
+		if err := recomputeCourseTotals(updated.Name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": &updated})
+}
+
+// deleteCourse 删除没有任何学生成绩的课程
+func deleteCourse(c *gin.Context) {
+	code := c.Query("code")
+	mu.Lock()
+	defer mu.Unlock()
+	coursesMu.Lock()
+	defer coursesMu.Unlock()
+	old, exists := courses[code]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "课程不存在"})
+		return
+	}
+	used := false
+	err := store.Range(func(stu *student) bool {
+		used = hasSubject(stu, old.Name)
+		return !used
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if used {
+		c.JSON(http.StatusConflict, gin.H{"error": errCourseHasScores.Error()})
+		return
+	}
+	delete(courses, code)
+	_ = rebuildCourseLookup()
+	if err := saveCourses(); err != nil {
+		courses[code] = old
+		_ = rebuildCourseLookup()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": ""})
+}
+
+// getCourse 根据课程代码、名称或别名查询课程
+func getCourse(c *gin.Context) {
+	coursesMu.RLock()
+	defer coursesMu.RUnlock()
+	code, ok := courseLookup[courseKey(c.Query("code"))]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "课程不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": courses[code]})
+}
+
+func listCourses(c *gin.Context) {
+	coursesMu.RLock()
+	defer coursesMu.RUnlock()
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": sortedCourses()})
+}
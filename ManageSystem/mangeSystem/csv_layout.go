@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 学生字段在CSV中的标准名称
+const (
+	fieldName   = "name"
+	fieldAge    = "age"
+	fieldSex    = "sex"
+	fieldClass  = "class"
+	fieldNumber = "number"
+	fieldScore  = "score"
+)
+
+// 成绩列的格式
+const (
+	layoutJSON = "json" //一列JSON对象，如 {"数学":90}
+	layoutWide = "wide" //学生字段之后每列一门课程，表头为课程名
+)
+
+// columnAliases 表头名称到标准字段的映射，键统一为小写且去掉首尾空白
+var columnAliases = map[string]string{
+	"name":           fieldName,
+	"姓名":             fieldName,
+	"学生姓名":           fieldName,
+	"age":            fieldAge,
+	"年龄":             fieldAge,
+	"sex":            fieldSex,
+	"gender":         fieldSex,
+	"性别":             fieldSex,
+	"class":          fieldClass,
+	"班级":             fieldClass,
+	"number":         fieldNumber,
+	"student number": fieldNumber,
+	"student_id":     fieldNumber,
+	"学号":             fieldNumber,
+	"score":          fieldScore,
+	"scores":         fieldScore,
+	"成绩":             fieldScore,
+}
+
+// loadColumnAliases 从JSON文件读取额外的表头别名，格式为 {"number": ["学生编号", "sid"]}
+func loadColumnAliases(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var extra map[string][]string
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+	for field, aliases := range extra {
+		switch field {
+		case fieldName, fieldAge, fieldSex, fieldClass, fieldNumber, fieldScore:
+		default:
+			return fmt.Errorf("未知的字段：%s", field)
+		}
+		for _, alias := range aliases {
+			columnAliases[normalizeHeader(alias)] = field
+		}
+	}
+	return nil
+}
+
+func normalizeHeader(cell string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(cell, "\ufeff")))
+}
+
+// csvLayout 记录各字段所在的列，列号从0开始，文件中没有的字段不出现
+type csvLayout struct {
+	columns  map[string]int
+	subjects []subjectColumn //宽表格式下的课程列，按列号从小到大排列
+	fixed    bool            //没有表头时按固定的六列顺序解析，要求列数严格一致
+}
+
+// subjectColumn 宽表格式下的一个课程列
+type subjectColumn struct {
+	index   int //从0开始的列号
+	subject string
+}
+
+// positionalLayout 没有表头时的列顺序：姓名、年龄、性别、班级、学号、JSON格式的成绩
+var positionalLayout = &csvLayout{
+	columns: map[string]int{fieldName: 0, fieldAge: 1, fieldSex: 2, fieldClass: 3, fieldNumber: 4, fieldScore: 5},
+	fixed:   true,
+}
+
+// detectLayout 判断首行是否为表头，是则按表头建立列映射
+// json格式下未识别的列被忽略；wide格式下最后一个学生字段之后的列都是课程，之前的未识别列被忽略
+func detectLayout(record []string, format string) (*csvLayout, bool, error) {
+	layout := &csvLayout{columns: make(map[string]int)}
+	for i, cell := range record {
+		field, ok := columnAliases[normalizeHeader(cell)]
+		if !ok {
+			continue
+		}
+		if _, dup := layout.columns[field]; dup {
+			return nil, true, &columnError{Column: i + 1, Msg: fmt.Sprintf("表头中%v列重复", strings.TrimSpace(cell))}
+		}
+		layout.columns[field] = i
+	}
+	if len(layout.columns) == 0 {
+		if format == layoutWide {
+			return nil, false, &columnError{Msg: "宽表格式需要表头"}
+		}
+		return positionalLayout, false, nil
+	}
+	if _, ok := layout.columns[fieldNumber]; !ok {
+		return nil, true, &columnError{Msg: "表头缺少学号列"}
+	}
+	if format == layoutWide {
+		if i, ok := layout.columns[fieldScore]; ok {
+			return nil, true, &columnError{Column: i + 1, Msg: "宽表格式不能包含成绩列"}
+		}
+		last := -1
+		for _, i := range layout.columns {
+			last = max(last, i)
+		}
+		layout.subjects = make([]subjectColumn, 0, len(record)-last-1)
+		seen := make(map[string]bool)
+		for i := last + 1; i < len(record); i++ {
+			subject := strings.TrimSpace(record[i])
+			if subject == "" {
+				continue
+			}
+			if seen[subject] {
+				return nil, true, &columnError{Column: i + 1, Msg: fmt.Sprintf("表头中%v列重复", subject)}
+			}
+			seen[subject] = true
+			layout.subjects = append(layout.subjects, subjectColumn{index: i, subject: subject})
+		}
+	}
+	return layout, true, nil
+}
+
+// cell 返回字段的值和从1开始的列号，字段不存在或该行列数不足时返回空值
+func (l *csvLayout) cell(record []string, field string) (string, int) {
+	i, ok := l.columns[field]
+	if !ok {
+		return "", 0
+	}
+	if i >= len(record) {
+		return "", i + 1
+	}
+	return record[i], i + 1
+}
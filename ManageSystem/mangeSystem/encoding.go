@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// CSV文件的编码，中文Excel导出的CSV通常是GBK
+const (
+	encodingUTF8    = "utf-8"
+	encodingUTF8BOM = "utf-8-bom"
+	encodingGBK     = "gbk"
+	encodingGB18030 = "gb18030"
+)
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// encodingDetector 在写入数据的同时判断内容是否为UTF-8，能够处理在块边界被截断的多字节字符
+type encodingDetector struct {
+	head    []byte //开头的几个字节，用于判断BOM
+	pending []byte //上一块末尾不完整的字符
+	invalid bool
+}
+
+func (d *encodingDetector) Write(p []byte) (int, error) {
+	if len(d.head) < len(utf8BOM) {
+		d.head = append(d.head, p[:min(len(p), len(utf8BOM)-len(d.head))]...)
+	}
+	if d.invalid {
+		return len(p), nil
+	}
+	data := append(d.pending, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	if !utf8.Valid(data[:cut]) {
+		d.invalid = true
+	}
+	d.pending = append([]byte(nil), data[cut:]...)
+	return len(p), nil
+}
+
+// result 返回检测到的编码，truncated表示只写入了文件开头的一部分，末尾不完整的字符不算错误
+// 不是UTF-8的内容按GB18030处理，它兼容GBK和GB2312
+func (d *encodingDetector) result(truncated bool) string {
+	if d.invalid || (!truncated && len(d.pending) > 0) {
+		return encodingGB18030
+	}
+	if bytes.HasPrefix(d.head, utf8BOM) {
+		return encodingUTF8BOM
+	}
+	return encodingUTF8
+}
+
+// detectEncoding 根据文件开头的一段内容判断编码
+func detectEncoding(head []byte) string {
+	var d encodingDetector
+	d.Write(head)
+	return d.result(true)
+}
+
+// detectFileEncoding 读取整个文件判断编码，避免开头都是ASCII时误判
+func detectFileEncoding(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	var d encodingDetector
+	if _, err := io.Copy(&d, file); err != nil {
+		return "", err
+	}
+	return d.result(false), nil
+}
+
+// validEncoding 检查导入参数中声明的编码
+func validEncoding(encoding string) error {
+	switch encoding {
+	case "", encodingUTF8, encodingUTF8BOM, encodingGBK, encodingGB18030:
+		return nil
+	default:
+		return fmt.Errorf("不支持的编码：%s", encoding)
+	}
+}
+
+// decodeReader 把指定编码的内容转换为UTF-8，并去掉开头的BOM
+func decodeReader(r io.Reader, encoding string) io.Reader {
+	switch encoding {
+	case encodingGBK:
+		return simplifiedchinese.GBK.NewDecoder().Reader(r)
+	case encodingGB18030:
+		return simplifiedchinese.GB18030.NewDecoder().Reader(r)
+	default:
+		buffered := bufio.NewReader(r)
+		if head, _ := buffered.Peek(len(utf8BOM)); bytes.Equal(head, utf8BOM) {
+			buffered.Discard(len(utf8BOM))
+		}
+		return buffered
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"net/http"
+	"sort"
+)
+
+const exportFlushRows = 500 //导出CSV时每写入多少行刷新一次
+
+// exportOptions 导出参数，通过查询参数传入
+type exportOptions struct {
+	Format  string   `form:"format"`  //csv（默认）或 xlsx
+	Layout  string   `form:"layout"`  //json（默认，与导入格式相同）或 wide（每门课程一列）
+	Class   string   `form:"class"`   //只导出该班级
+	Subject []string `form:"subject"` //只导出有这些课程成绩的学生，且只包含这些课程
+}
+
+// exportHeader 导出文件的表头，与导入时识别的字段名一致，便于再次导入
+var exportHeader = []string{fieldName, fieldAge, fieldSex, fieldClass, fieldNumber}
+
+// selectExport 按筛选条件取出要导出的学生，指定课程时只保留这些课程的成绩，宽表格式下同时取出涉及的全部课程
+// 存储中的学生写入后不再被修改（写入前都会先复制），因此只在收集时持有锁，释放后再慢慢写出
+func selectExport(opts exportOptions) ([]*student, []string, error) {
+	wanted := make(map[string]bool)
+	for _, subject := range opts.Subject {
+		wanted[subject] = true
+	}
+	var list []*student
+	subjects := make(map[string]bool)
+	mu.Lock()
+	err := rangeIndexed(opts.Class, nil, func(stu *student) bool {
+		if opts.Class != "" && stu.Class != opts.Class {
+			return true
+		}
+		if len(wanted) > 0 {
+			filtered := make(map[string]grade)
+			for subject, score := range stu.Scores {
+				if wanted[subject] {
+					filtered[subject] = score
+				}
+			}
+			if len(filtered) == 0 {
+				return true
+			}
+			c := *stu
+			c.Scores = filtered
+			stu = &c
+		}
+		if opts.Layout == layoutWide {
+			for subject := range stu.Scores {
+				subjects[subject] = true
+			}
+		}
+		list = append(list, stu)
+		return true
+	})
+	mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, 0, len(subjects))
+	for subject := range subjects {
+		names = append(names, subject)
+	}
+	sort.Strings(names)
+	return list, names, nil
+}
+
+// exportRow 把一名学生转换为导出的一行，宽表格式下没有成绩的课程为空
+func exportRow(stu *student, layout string, subjects []string) ([]interface{}, error) {
+	row := []interface{}{stu.Name, stu.Age, stu.Sex, stu.Class, stu.Number}
+	if layout == layoutWide {
+		for _, subject := range subjects {
+			if g, ok := stu.Scores[subject]; ok {
+				//数值成绩在XLSX中保存为数字，其他成绩保存为文本
+				if score, numeric := g.numeric(); numeric {
+					row = append(row, score)
+				} else {
+					row = append(row, g.Mark)
+				}
+			} else {
+				row = append(row, "")
+			}
+		}
+		return row, nil
+	}
+	scores := stu.Scores
+	if scores == nil {
+		scores = map[string]grade{}
+	}
+	data, err := json.Marshal(scores)
+	if err != nil {
+		return nil, err
+	}
+	return append(row, string(data)), nil
+}
+
+// exportStudents 按导入使用的格式导出学生和成绩，导出的文件可以直接再次导入
+func exportStudents(c *gin.Context) {
+	var opts exportOptions
+	if err := c.ShouldBindQuery(&opts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if opts.Layout == "" {
+		opts.Layout = layoutJSON
+	}
+	if opts.Layout != layoutJSON && opts.Layout != layoutWide {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("未知的layout：%s", opts.Layout)})
+		return
+	}
+	if opts.Format == "" {
+		opts.Format = uploadCSV
+	}
+	if opts.Format != uploadCSV && opts.Format != uploadXLSX {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("未知的format：%s", opts.Format)})
+		return
+	}
+	for i, subject := range opts.Subject {
+		name, _, _, err := canonicalScoreKey(subject)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		opts.Subject[i] = name
+	}
+	list, subjects, err := selectExport(opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	header := append([]string{}, exportHeader...)
+	if opts.Layout == layoutWide {
+		header = append(header, subjects...)
+	} else {
+		header = append(header, fieldScore)
+	}
+	if opts.Format == uploadXLSX {
+		f, err := buildXLSXExport(list, opts.Layout, header, subjects)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer f.Close()
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		c.Header("Content-Disposition", `attachment; filename="students.xlsx"`)
+		c.Status(http.StatusOK)
+		if err := f.Write(c.Writer); err != nil {
+			c.Error(err)
+			c.Abort()
+		}
+		return
+	}
+	if err := writeCSVExport(c, list, opts.Layout, header, subjects); err != nil {
+		//响应头可能已经发出，只能中断连接
+		c.Error(err)
+		c.Abort()
+	}
+}
+
+// writeCSVExport 逐行写出CSV，每写入一批刷新一次，不必等全部生成
+func writeCSVExport(c *gin.Context, list []*student, layout string, header []string, subjects []string) error {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="students.csv"`)
+	c.Status(http.StatusOK)
+	//写入BOM，方便Excel正确识别中文，导入时会自动去掉
+	if _, err := c.Writer.Write(utf8BOM); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(c.Writer)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for i, stu := range list {
+		row, err := exportRow(stu, layout, subjects)
+		if err != nil {
+			return err
+		}
+		record := make([]string, len(row))
+		for j, cell := range row {
+			record[j] = fmt.Sprint(cell)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+		if (i+1)%exportFlushRows == 0 {
+			writer.Flush()
+			c.Writer.Flush()
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// buildXLSXExport 通过StreamWriter逐行写入工作表，数据较多时由excelize暂存到磁盘
+func buildXLSXExport(list []*student, layout string, header []string, subjects []string) (*excelize.File, error) {
+	f := excelize.NewFile()
+	const sheet = "Sheet1"
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	headerRow := make([]interface{}, len(header))
+	for i, name := range header {
+		headerRow[i] = name
+	}
+	if err := sw.SetRow("A1", headerRow); err != nil {
+		f.Close()
+		return nil, err
+	}
+	for i, stu := range list {
+		var row []interface{}
+		row, err = exportRow(stu, layout, subjects)
+		if err != nil {
+			break
+		}
+		var cell string
+		cell, err = excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			break
+		}
+		if err = sw.SetRow(cell, row); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = sw.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
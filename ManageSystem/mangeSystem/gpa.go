@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// gpaBand 绩点表的一档：得分率不低于Min（百分比）时绩点为Points+(得分率-Min)*Step
+// Step为0时是固定绩点，不为0时可以表示“分数/10-5”这类线性换算
+type gpaBand struct {
+	Min    float64 `json:"min"`
+	Points float64 `json:"points"`
+	Step   float64 `json:"step,omitempty"`
+}
+
+// gpaScale 绩点换算规则，数值成绩按满分换算为得分率后查Bands，等级制成绩查Letters
+type gpaScale struct {
+	Name    string             `json:"name"`
+	Max     float64            `json:"max"`
+	Bands   []gpaBand          `json:"bands"`
+	Letters map[string]float64 `json:"letters"`
+}
+
+// defaultCredits 没有在课程注册表中登记或登记时没有填写学分的课程按1学分计算
+const defaultCredits = 1.0
+
+// gpaScales 可用的绩点换算规则，启动时可从文件加载自定义规则
+var gpaScales = map[string]*gpaScale{
+	"4.0": {
+		Name: "4.0",
+		Max:  4,
+		Bands: []gpaBand{
+			{Min: 90, Points: 4.0}, {Min: 85, Points: 3.7}, {Min: 82, Points: 3.3}, {Min: 78, Points: 3.0},
+			{Min: 75, Points: 2.7}, {Min: 72, Points: 2.3}, {Min: 68, Points: 2.0}, {Min: 64, Points: 1.5},
+			{Min: 60, Points: 1.0}, {Min: 0, Points: 0},
+		},
+		Letters: map[string]float64{
+			"A+": 4.0, "A": 4.0, "A-": 3.7, "B+": 3.3, "B": 3.0, "B-": 2.7,
+			"C+": 2.3, "C": 2.0, "C-": 1.7, "D+": 1.3, "D": 1.0, "D-": 0.7, "F": 0,
+		},
+	},
+	"5.0": {
+		Name:  "5.0",
+		Max:   5,
+		Bands: []gpaBand{{Min: 60, Points: 1.0, Step: 0.1}, {Min: 0, Points: 0}},
+		Letters: map[string]float64{
+			"A+": 5.0, "A": 4.8, "A-": 4.5, "B+": 4.2, "B": 3.8, "B-": 3.5,
+			"C+": 3.2, "C": 2.8, "C-": 2.5, "D+": 2.2, "D": 1.8, "D-": 1.5, "F": 0,
+		},
+	},
+}
+
+// loadGPAScales 从JSON文件读取自定义绩点规则，格式为规则数组，与内置规则同名时覆盖内置规则
+func loadGPAScales(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var list []*gpaScale
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("绩点规则文件格式错误：%v", err)
+	}
+	for _, scale := range list {
+		if scale.Name == "" || len(scale.Bands) == 0 {
+			return fmt.Errorf("绩点规则需要名称和至少一档换算")
+		}
+		if !(scale.Max > 0) || !finite(scale.Max) {
+			return fmt.Errorf("绩点规则%v的最高绩点必须大于0", scale.Name)
+		}
+		if len(scale.Letters) == 0 {
+			return fmt.Errorf("绩点规则%v缺少等级制成绩的换算表", scale.Name)
+		}
+		sort.Slice(scale.Bands, func(a, b int) bool {
+			return scale.Bands[a].Min > scale.Bands[b].Min
+		})
+		gpaScales[scale.Name] = scale
+	}
+	return nil
+}
+
+// points 把一门课程的成绩换算为绩点，合格制和免考等成绩不计入绩点，返回false
+func (s *gpaScale) points(g grade, maxScore int) (float64, bool) {
+	switch g.Mark {
+	case "":
+		percent := g.Score / float64(maxScore) * 100
+		for _, band := range s.Bands {
+			if percent >= band.Min {
+				return math.Min(band.Points+(percent-band.Min)*band.Step, s.Max), true
+			}
+		}
+		return 0, true
+	case markAbsent, markCheating:
+		return 0, true
+	case markPass, markFail, markExempt:
+		return 0, false
+	default:
+		points, ok := s.Letters[g.Mark]
+		return points, ok
+	}
+}
+
+// passed 判断一门课程是否通过，免考视为通过
+func passed(g grade, passLine int) bool {
+	switch g.Mark {
+	case "":
+		return g.Score >= float64(passLine)
+	case markPass, markExempt:
+		return true
+	case markFail, markAbsent, markCheating, "F":
+		return false
+	default:
+		return true //D-及以上的等级
+	}
+}
+
+// transcriptCourse 成绩单中的一门课程
+type transcriptCourse struct {
+	Subject string   `json:"subject"`
+	Grade   grade    `json:"grade"`
+	Credits float64  `json:"credits"`
+	Points  *float64 `json:"points"` //不计入绩点的成绩为null
+	Passed  bool     `json:"passed"`
+}
+
+// gpaSummary 一个学期或全部学期的汇总
+type gpaSummary struct {
+	GPA           *float64 `json:"gpa"`           //没有计入绩点的课程时为null
+	GPACredits    float64  `json:"gpaCredits"`    //计入绩点的学分
+	TotalCredits  float64  `json:"totalCredits"`  //修读的全部学分
+	EarnedCredits float64  `json:"earnedCredits"` //通过课程的学分
+	Passed        int      `json:"passed"`
+	Failed        int      `json:"failed"`
+	weighted      float64
+}
+
+// termTranscript 一个学期的成绩单
+type termTranscript struct {
+	Term    string             `json:"term"`
+	Courses []transcriptCourse `json:"courses"`
+	gpaSummary
+}
+
+// add 把一门课程计入汇总
+func (s *gpaSummary) add(course transcriptCourse) {
+	s.TotalCredits += course.Credits
+	if course.Passed {
+		s.Passed++
+		s.EarnedCredits += course.Credits
+	} else {
+		s.Failed++
+	}
+	if course.Points != nil && course.Credits > 0 {
+		s.GPACredits += course.Credits
+		s.weighted += *course.Points * course.Credits
+	}
+}
+
+// finish 计算平均绩点，保留两位小数
+func (s *gpaSummary) finish() {
+	if s.GPACredits > 0 {
+		gpa := math.Round(s.weighted/s.GPACredits*100) / 100
+		s.GPA = &gpa
+	}
+}
+
+// buildTranscript 按学期计算学生的成绩单和绩点，考核项不单独计入，调用方需持有mu
+func buildTranscript(stu *student, scale *gpaScale) ([]termTranscript, gpaSummary) {
+	coursesMu.RLock()
+	defer coursesMu.RUnlock()
+	var cumulative gpaSummary
+	terms := make([]termTranscript, 0)
+	for _, ts := range transcript(stu) {
+		tt := termTranscript{Term: ts.Term, Courses: make([]transcriptCourse, 0, len(ts.Scores))}
+		for subject, g := range ts.Scores {
+			if isComponentKeyLocked(subject) {
+				continue
+			}
+			credits, maxScore, passLine := defaultCredits, 100, 60
+			if code, ok := courseLookup[courseKey(subject)]; ok {
+				c := courses[code]
+				credits, maxScore, passLine = c.Credits, c.MaxScore, c.PassLine
+			}
+			course := transcriptCourse{Subject: subject, Grade: g, Credits: credits, Passed: passed(g, passLine)}
+			if points, ok := scale.points(g, maxScore); ok {
+				points = math.Round(points*100) / 100
+				course.Points = &points
+			}
+			tt.Courses = append(tt.Courses, course)
+			tt.add(course)
+			cumulative.add(course)
+		}
+		sort.Slice(tt.Courses, func(a, b int) bool {
+			return tt.Courses[a].Subject < tt.Courses[b].Subject
+		})
+		tt.finish()
+		terms = append(terms, tt)
+	}
+	cumulative.finish()
+	return terms, cumulative
+}
+
+// getTranscript 查询学生各学期的成绩单、学期绩点和累计绩点，scale指定绩点规则，默认4.0
+func getTranscript(c *gin.Context) {
+	number := c.Query("number")
+	scaleName := c.DefaultQuery("scale", "4.0")
+	scale, ok := gpaScales[scaleName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("未知的绩点规则：%s", scaleName)})
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	stu, exists, err := store.Get(number)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "学生不存在"})
+		return
+	}
+	terms, cumulative := buildTranscript(stu, scale)
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": gin.H{
+			"number":     stu.Number,
+			"name":       stu.Name,
+			"scale":      scale.Name,
+			"terms":      terms,
+			"cumulative": cumulative,
+		}})
+}
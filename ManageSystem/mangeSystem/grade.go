@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 等级制成绩，从高到低排列
+var letterGrades = []string{"A+", "A", "A-", "B+", "B", "B-", "C+", "C", "C-", "D+", "D", "D-", "F"}
+
+// 特殊成绩的标准写法
+const (
+	markPass     = "合格"
+	markFail     = "不合格"
+	markAbsent   = "缺考"
+	markExempt   = "免考"
+	markCheating = "作弊"
+)
+
+// markAliases 特殊成绩的其他写法，键统一为小写
+var markAliases = map[string]string{
+	"合格": markPass, "通过": markPass, "pass": markPass,
+	"不合格": markFail, "未通过": markFail, "fail": markFail,
+	"缺考": markAbsent, "absent": markAbsent,
+	"免考": markExempt, "免修": markExempt, "exempt": markExempt,
+	"作弊": markCheating, "cheating": markCheating,
+}
+
+// grade 一门课程的成绩：百分制等数值成绩（可以有小数）、等级制成绩、合格制成绩或缺考等特殊情况
+// Mark为空时是数值成绩，JSON中数值成绩仍然是数字，其他成绩是字符串
+type grade struct {
+	Score float64
+	Mark  string //等级（如 A-）或特殊成绩的标准写法
+}
+
+// numGrade 数值成绩
+func numGrade(score float64) grade {
+	return grade{Score: score}
+}
+
+// parseGrade 解析文本形式的成绩，如 "89.5"、"a-"、"合格"、"缺考"
+func parseGrade(text string) (grade, error) {
+	text = strings.TrimSpace(text)
+	//ParseFloat还接受NaN、Inf和十六进制写法，这些都不是有效的成绩
+	if score, err := strconv.ParseFloat(text, 64); err == nil && finite(score) && !strings.ContainsAny(text, "xX_") {
+		return numGrade(score), nil
+	}
+	upper := strings.ToUpper(text)
+	for _, letter := range letterGrades {
+		if upper == letter {
+			return grade{Mark: letter}, nil
+		}
+	}
+	if mark, ok := markAliases[strings.ToLower(text)]; ok {
+		return grade{Mark: mark}, nil
+	}
+	return grade{}, fmt.Errorf("无法识别的成绩：%s", text)
+}
+
+// finite 判断分数不是NaN或无穷大，否则无法写成JSON
+func finite(score float64) bool {
+	return !math.IsNaN(score) && !math.IsInf(score, 0)
+}
+
+// numeric 返回数值成绩，其他成绩返回false
+func (g grade) numeric() (float64, bool) {
+	return g.Score, g.Mark == ""
+}
+
+func (g grade) String() string {
+	if g.Mark != "" {
+		return g.Mark
+	}
+	return strconv.FormatFloat(g.Score, 'f', -1, 64)
+}
+
+func (g grade) MarshalJSON() ([]byte, error) {
+	if g.Mark != "" {
+		return json.Marshal(g.Mark)
+	}
+	return []byte(g.String()), nil
+}
+
+func (g *grade) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var score float64
+		if err := json.Unmarshal(data, &score); err != nil || !finite(score) {
+			return fmt.Errorf("成绩必须是数字或字符串：%s", data)
+		}
+		*g = numGrade(score)
+		return nil
+	}
+	parsed, err := parseGrade(text)
+	if err != nil {
+		return err
+	}
+	*g = parsed
+	return nil
+}
@@ -0,0 +1,767 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	uploadDir     = "./postFile"  //上传文件的保存目录
+	importingDir  = "./importing" //导入任务认领的文件移动到这里，避免被其他任务重复读取
+	importWorkers = 10            //每个导入任务写入存储的协程数
+)
+
+// ParseError 记录读取CSV文件错误的结构体，Line和Column从1开始，为0表示整行或整个文件
+type ParseError struct {
+	File   string `json:"file,omitempty"`
+	Sheet  string `json:"sheet,omitempty"` //XLSX文件的工作表名称
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Msg    string `json:"msg"`
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("第%v行第%v列：%v", e.Line, e.Column, e.Msg)
+}
+
+// columnError 解析某一列失败时返回的错误，用于在ParseError中定位列号
+type columnError struct {
+	Column int
+	Msg    string
+}
+
+func (e *columnError) Error() string {
+	if e.Column == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("第%v列%v", e.Column, e.Msg)
+}
+
+// importConflict 学号已存在的一行及其处理结果
+type importConflict struct {
+	File   string `json:"file,omitempty"`
+	Sheet  string `json:"sheet,omitempty"`
+	Line   int    `json:"line"`
+	Number string `json:"number"`
+	Action string `json:"action"` //overwritten、merged 或 kept
+}
+
+// parsedRow 解析成功的一行，校验模式下作为结果返回
+type parsedRow struct {
+	File    string  `json:"file,omitempty"`
+	Sheet   string  `json:"sheet,omitempty"`
+	Line    int     `json:"line"`
+	Student student `json:"student"`
+}
+
+// importReport 导入结果，返回给调用方用于修正表格
+type importReport struct {
+	DryRun      bool             `json:"dryRun"`
+	Atomic      bool             `json:"atomic"`
+	RolledBack  bool             `json:"rolledBack"` //原子模式下因存在错误而没有写入任何数据
+	Imported    int              `json:"imported"`   //成功导入的行数
+	Skipped     int              `json:"skipped"`    //因错误跳过的行数
+	Inserted    int              `json:"inserted"`
+	Overwritten int              `json:"overwritten"`
+	Merged      int              `json:"merged"`
+	Kept        int              `json:"kept"`
+	Conflicts   []importConflict `json:"conflicts"`
+	Errors      []ParseError     `json:"errors"`
+	Rows        []parsedRow      `json:"rows,omitempty"` //校验模式下解析出的全部学生
+}
+
+// 学号已存在时的处理方式
+const (
+	modeInsert    = "insert"    //跳过已存在的学生
+	modeOverwrite = "overwrite" //用导入的数据整体替换
+	modeMerge     = "merge"     //更新非空的基本信息，成绩按科目合并
+)
+
+// 每一行导入后的结果
+const (
+	actionInserted    = "inserted"
+	actionOverwritten = "overwritten"
+	actionMerged      = "merged"
+	actionKept        = "kept" //insert模式下学号已存在，保留原数据
+)
+
+// importOptions 导入参数，通过查询参数传入
+type importOptions struct {
+	Layout   string   `form:"layout"`   //成绩列格式：json（默认，一列JSON）或 wide（表头之后每列一门课程）
+	Mode     string   `form:"mode"`     //学号冲突时的处理方式：insert、overwrite（默认）或 merge
+	DryRun   bool     `form:"dryRun"`   //只解析和校验，不写入存储
+	Atomic   bool     `form:"atomic"`   //全部行都解析成功才一次性写入，否则不做任何修改
+	Upload   []string `form:"upload"`   //只导入指定ID的上传文件，为空时导入全部
+	Encoding string   `form:"encoding"` //CSV文件的编码：utf-8、gbk 或 gb18030，为空时自动识别
+}
+
+// bindImportOptions 读取并检查导入参数
+func bindImportOptions(c *gin.Context) (importOptions, error) {
+	var opts importOptions
+	if err := c.ShouldBindQuery(&opts); err != nil {
+		return opts, err
+	}
+	switch opts.Layout {
+	case "":
+		opts.Layout = layoutJSON
+	case layoutJSON, layoutWide:
+	default:
+		return opts, fmt.Errorf("未知的layout：%s", opts.Layout)
+	}
+	switch opts.Mode {
+	case "":
+		opts.Mode = modeOverwrite
+	case modeInsert, modeOverwrite, modeMerge:
+	default:
+		return opts, fmt.Errorf("未知的mode：%s", opts.Mode)
+	}
+	opts.Encoding = strings.ToLower(opts.Encoding)
+	if err := validEncoding(opts.Encoding); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// importJob 一次导入任务，每个任务拥有独立的通道、工作协程和错误收集，
+// 因此可以在同一进程中多次或并发执行
+type importJob struct {
+	ID          string
+	opts        importOptions
+	studentChan []chan parsedRow //存储读取文件时的数据，每个工作协程一个通道
+	errorChan   chan ParseError  //存储读取文件时产生的错误
+	workers     sync.WaitGroup
+	collector   sync.WaitGroup
+	read        atomic.Int64 //已读取的行数
+	imported    atomic.Int64 //已写入存储的行数
+	failed      atomic.Int64 //出错的行数，同一行的多个错误只计一次
+	errors      []ParseError //任务结束后收集到的全部错误
+	resultMu    sync.Mutex
+	actions     map[string]int   //各处理结果的行数
+	conflicts   []importConflict //学号已存在的行
+	staged      []parsedRow      //校验或原子模式下解析成功的行
+	holding     bool             //为true时解析出的行先留在held中，不交给工作协程
+	held        []parsedRow
+}
+
+// staging 解析成功的行是否先暂存，而不是由工作协程直接写入
+func (j *importJob) staging() bool {
+	return j.opts.DryRun || j.opts.Atomic
+}
+
+func newImportJob(opts importOptions) *importJob {
+	return &importJob{
+		ID:          newID(),
+		opts:        opts,
+		actions:     make(map[string]int),
+		studentChan: make([]chan parsedRow, importWorkers),
+		errorChan:   make(chan ParseError, 1000),
+	}
+}
+
+// run 依次解析上传文件并导入，返回时所有数据都已写入存储
+func (j *importJob) run(uploads []uploadMeta) importReport {
+	return j.runFeed(func() {
+		for _, upload := range uploads {
+			j.parseUpload(upload)
+		}
+	})
+}
+
+// runFeed 启动工作协程后调用feed读取数据，feed通过parseReader把每个文件交给任务
+func (j *importJob) runFeed(feed func()) importReport {
+	j.collector.Add(1)
+	go func() {
+		defer j.collector.Done()
+		failedLines := make(map[rowKey]bool)
+		for parseErr := range j.errorChan {
+			key := rowKey{parseErr.File, parseErr.Sheet, parseErr.Line}
+			if parseErr.Line > 0 && !failedLines[key] {
+				failedLines[key] = true
+				j.failed.Add(1)
+			}
+			j.errors = append(j.errors, parseErr)
+		}
+	}()
+	for i := range j.studentChan {
+		j.studentChan[i] = make(chan parsedRow, 1000/importWorkers)
+		j.workers.Add(1)
+		go j.worker(j.studentChan[i])
+	}
+	feed()
+	for _, ch := range j.studentChan {
+		close(ch)
+	}
+	j.workers.Wait()
+	close(j.errorChan)
+	j.collector.Wait()
+	sort.Slice(j.staged, func(a, b int) bool {
+		x, y := j.staged[a], j.staged[b]
+		return rowLess(x.File, x.Sheet, x.Line, y.File, y.Sheet, y.Line)
+	})
+	rolledBack := false
+	switch {
+	case j.opts.DryRun:
+		rowErrors, err := j.commitStaged(false)
+		j.errors = append(j.errors, rowErrors...)
+		if err != nil {
+			j.errors = append(j.errors, ParseError{Msg: fmt.Sprintf("校验失败：%v", err)})
+		}
+	case j.opts.Atomic:
+		if len(j.errors) > 0 {
+			rolledBack = true
+		} else if rowErrors, err := j.commitStaged(true); err != nil {
+			j.errors = append(j.errors, ParseError{Msg: fmt.Sprintf("写入失败，未导入任何数据：%v", err)})
+			rolledBack = true
+		} else if len(rowErrors) > 0 {
+			j.errors = append(j.errors, rowErrors...)
+			rolledBack = true
+		}
+	}
+	parseErrors := j.errors
+	if parseErrors == nil {
+		parseErrors = []ParseError{}
+	}
+	conflicts := j.conflicts
+	if conflicts == nil {
+		conflicts = []importConflict{}
+	}
+	sort.Slice(conflicts, func(a, b int) bool {
+		x, y := conflicts[a], conflicts[b]
+		return rowLess(x.File, x.Sheet, x.Line, y.File, y.Sheet, y.Line)
+	})
+	var rows []parsedRow
+	if j.opts.DryRun {
+		rows = j.staged
+		if rows == nil {
+			rows = []parsedRow{}
+		}
+	}
+	return importReport{
+		DryRun:      j.opts.DryRun,
+		Atomic:      j.opts.Atomic,
+		RolledBack:  rolledBack,
+		Imported:    int(j.imported.Load()),
+		Skipped:     countSkipped(parseErrors),
+		Inserted:    j.actions[actionInserted],
+		Overwritten: j.actions[actionOverwritten],
+		Merged:      j.actions[actionMerged],
+		Kept:        j.actions[actionKept],
+		Conflicts:   conflicts,
+		Errors:      parseErrors,
+		Rows:        rows,
+	}
+}
+
+// rowLess 按文件、工作表、行号排序
+func rowLess(fileA, sheetA string, lineA int, fileB, sheetB string, lineB int) bool {
+	if fileA != fileB {
+		return fileA < fileB
+	}
+	if sheetA != sheetB {
+		return sheetA < sheetB
+	}
+	return lineA < lineB
+}
+
+// rowKey 标识导入文件中的一行
+type rowKey struct {
+	file  string
+	sheet string
+	line  int
+}
+
+// countSkipped 统计出错的行数，同一行的多个错误只计一次，文件级错误不计入
+func countSkipped(parseErrors []ParseError) int {
+	lines := make(map[rowKey]bool)
+	for _, e := range parseErrors {
+		if e.Line > 0 {
+			lines[rowKey{e.File, e.Sheet, e.Line}] = true
+		}
+	}
+	return len(lines)
+}
+
+// parseUpload 按文件类型解析一个上传文件
+func (j *importJob) parseUpload(upload uploadMeta) {
+	switch upload.Type {
+	case uploadCSV:
+		encoding := upload.Encoding
+		if j.opts.Encoding != "" {
+			encoding = j.opts.Encoding
+		}
+		j.parseFile(upload.path, upload.FileName, encoding)
+	case uploadXLSX:
+		j.parseXLSXFile(upload.path, upload.FileName)
+	default:
+		j.errorChan <- ParseError{File: upload.FileName, Msg: fmt.Sprintf("暂不支持%v文件", upload.Type)}
+	}
+}
+
+// 读取CSV文件，把解析出的学生交给工作协程
+func (j *importJob) parseFile(filePath, name, encoding string) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		j.errorChan <- ParseError{File: name, Msg: fmt.Sprintf("无法打开文件：%v", err)}
+		return
+	}
+	defer file.Close()
+	j.parseReader(name, decodeReader(file, encoding))
+}
+
+// parseReader 逐行读取UTF-8编码的CSV数据，把解析出的学生交给工作协程，name用于错误报告
+func (j *importJob) parseReader(name string, r io.Reader) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 //列数由parseStudent检查，以便报告具体原因
+	j.parseRows(name, "", func() ([]string, int, error) {
+		record, err := reader.Read()
+		if err != nil {
+			var csvErr *csv.ParseError
+			if errors.As(err, &csvErr) {
+				return nil, csvErr.Line, &ParseError{Line: csvErr.Line, Column: csvErr.Column, Msg: fmt.Sprintf("读取数据有误：%v", csvErr.Err)}
+			}
+			return nil, 0, err
+		}
+		line, _ := reader.FieldPos(0)
+		return record, line, nil
+	})
+}
+
+// nextRow 依次返回表格中的一行及其行号，结束时返回io.EOF；
+// 返回*ParseError表示该行读取失败但可以继续，其他错误会终止整个文件
+type nextRow func() (record []string, line int, err error)
+
+// parseRows 识别表头并逐行解析，把解析出的学生交给工作协程，CSV和XLSX共用
+func (j *importJob) parseRows(name, sheet string, next nextRow) {
+	var layout *csvLayout //读到第一行时确定
+	for {
+		record, line, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var rowErr *ParseError
+			if errors.As(err, &rowErr) {
+				j.read.Add(1)
+				rowErr.File, rowErr.Sheet = name, sheet
+				j.errorChan <- *rowErr
+				continue
+			}
+			j.errorChan <- ParseError{File: name, Sheet: sheet, Msg: fmt.Sprintf("读取文件失败：%v", err)}
+			return
+		}
+		if layout == nil {
+			var isHeader bool
+			layout, isHeader, err = detectLayout(record, j.opts.Layout)
+			if err != nil {
+				j.errorChan <- fileError(name, sheet, line, err)
+				return
+			}
+			if isHeader {
+				continue
+			}
+		}
+		j.read.Add(1)
+		//封装结构体数据
+		student, err := parseStudent(record, layout)
+		if err != nil {
+			parseErr := ParseError{File: name, Sheet: sheet, Line: line, Msg: fmt.Sprintf("解析失败：%v", err)}
+			var colErr *columnError
+			if errors.As(err, &colErr) {
+				parseErr.Column = colErr.Column
+				parseErr.Msg = colErr.Msg
+			}
+			j.errorChan <- parseErr
+			continue
+		}
+		j.dispatch(parsedRow{File: name, Sheet: sheet, Line: line, Student: student})
+	}
+}
+
+// fileError 导致整个文件或工作表无法导入的错误，不计入出错行数
+func fileError(name, sheet string, line int, err error) ParseError {
+	parseErr := ParseError{File: name, Sheet: sheet, Msg: err.Error()}
+	var colErr *columnError
+	if errors.As(err, &colErr) {
+		parseErr.Column = colErr.Column
+		parseErr.Msg = fmt.Sprintf("第%v行%v", line, colErr.Msg)
+	}
+	return parseErr
+}
+
+// dispatch 按学号把一行交给固定的工作协程，同一学号出现多次时按读取顺序依次写入
+// holding期间只在读取文件的协程中暂存，不写入存储
+func (j *importJob) dispatch(row parsedRow) {
+	if j.holding {
+		j.held = append(j.held, row)
+		return
+	}
+	h := fnv.New32a()
+	h.Write([]byte(row.Student.Number))
+	j.studentChan[h.Sum32()%uint32(len(j.studentChan))] <- row
+}
+
+func (j *importJob) worker(rows <-chan parsedRow) {
+	//从通道读取结构体数值并写入存储，使用mu保证原子性
+	defer j.workers.Done()
+	for row := range rows {
+		if j.staging() {
+			j.resultMu.Lock()
+			j.staged = append(j.staged, row)
+			j.resultMu.Unlock()
+			continue
+		}
+		mu.Lock()
+		action, err := applyImport(&row.Student, j.opts.Mode)
+		mu.Unlock()
+		if err != nil {
+			j.errorChan <- ParseError{File: row.File, Sheet: row.Sheet, Line: row.Line, Msg: fmt.Sprintf("保存学号%v失败：%v", row.Student.Number, err)}
+			continue
+		}
+		j.record(row, action)
+	}
+}
+
+// record 记录一行的处理结果
+func (j *importJob) record(row parsedRow, action string) {
+	if action != actionKept {
+		j.imported.Add(1)
+	}
+	j.resultMu.Lock()
+	defer j.resultMu.Unlock()
+	j.actions[action]++
+	if action != actionInserted {
+		j.conflicts = append(j.conflicts, importConflict{File: row.File, Sheet: row.Sheet, Line: row.Line, Number: row.Student.Number, Action: action})
+	}
+}
+
+// commitStaged 原子模式下把暂存的行一次性写入存储，同一学号出现多次时按行号顺序依次处理
+// 有无法写入的行时返回这些行的错误，不写入任何数据；write为false时只检查，用于校验模式
+func (j *importJob) commitStaged(write bool) ([]ParseError, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	pending := make(map[string]*student)
+	var order []string
+	var rowErrors []ParseError
+	actions := make([]string, len(j.staged))
+	for i, row := range j.staged {
+		existing, exists := pending[row.Student.Number]
+		if !exists {
+			var err error
+			existing, exists, err = store.Get(row.Student.Number)
+			if err != nil {
+				return nil, err
+			}
+		}
+		stu := row.Student
+		result, action, err := resolveImport(&stu, existing, exists, j.opts.Mode)
+		if err != nil {
+			rowErrors = append(rowErrors, ParseError{File: row.File, Sheet: row.Sheet, Line: row.Line, Msg: err.Error()})
+			continue
+		}
+		actions[i] = action
+		if action == actionKept {
+			continue
+		}
+		if _, ok := pending[stu.Number]; !ok {
+			order = append(order, stu.Number)
+		}
+		pending[stu.Number] = result
+	}
+	if !write || len(rowErrors) > 0 {
+		return rowErrors, nil
+	}
+	batch := make([]*student, 0, len(order))
+	for _, number := range order {
+		batch = append(batch, pending[number])
+	}
+	if err := store.PutAll(batch); err != nil {
+		return nil, err
+	}
+	for i, row := range j.staged {
+		j.record(row, actions[i])
+	}
+	return nil, nil
+}
+
+// applyImport 按冲突处理方式把一名学生写入存储，调用方需持有mu
+func applyImport(stu *student, mode string) (string, error) {
+	existing, exists, err := store.Get(stu.Number)
+	if err != nil {
+		return "", err
+	}
+	result, action, err := resolveImport(stu, existing, exists, mode)
+	if err != nil {
+		return "", err
+	}
+	if action == actionKept {
+		return action, nil
+	}
+	if err := store.Put(result); err != nil {
+		return "", err
+	}
+	return action, nil
+}
+
+// resolveImport 根据冲突处理方式计算应写入的学生信息，existing不会被修改
+// 转入的班级必须未归档，已在归档班级中的学生仍可更新
+func resolveImport(stu, existing *student, exists bool, mode string) (*student, string, error) {
+	result, action := mergeImport(stu, existing, exists, mode)
+	if action == actionKept {
+		return nil, action, nil
+	}
+	oldClass := ""
+	if exists {
+		oldClass = existing.Class
+	}
+	if err := checkClassChange(oldClass, result.Class); err != nil {
+		return nil, "", err
+	}
+	return result, action, nil
+}
+
+func mergeImport(stu, existing *student, exists bool, mode string) (*student, string) {
+	if !exists {
+		return stu, actionInserted
+	}
+	switch mode {
+	case modeInsert:
+		return nil, actionKept
+	case modeMerge:
+		merged := existing.clone()
+		mergeStudent(merged, stu)
+		return merged, actionMerged
+	default:
+		//导入只包含当前学期的成绩，其他学期的成绩保留
+		if existing.Terms != nil {
+			overwritten := *stu
+			overwritten.Terms = existing.clone().Terms
+			return &overwritten, actionOverwritten
+		}
+		return stu, actionOverwritten
+	}
+}
+
+// mergeStudent 用导入数据中非空的基本信息覆盖原数据，成绩按科目合并
+func mergeStudent(dst, src *student) {
+	if src.Name != "" {
+		dst.Name = src.Name
+	}
+	if src.Age != "" {
+		dst.Age = src.Age
+	}
+	if src.Sex != "" {
+		dst.Sex = src.Sex
+	}
+	if src.Class != "" {
+		dst.Class = src.Class
+	}
+	if len(src.Scores) > 0 && dst.Scores == nil {
+		dst.Scores = make(map[string]grade)
+	}
+	for subject, score := range src.Scores {
+		dst.Scores[subject] = score
+	}
+	computeTotals(dst.Scores)
+}
+
+// importUploads 认领上传目录中的文件并执行导入，结束后删除已读的文件，防止后续文件重名的问题
+// 校验模式下文件保留在上传目录，确认无误后可以再正式导入；原子模式回滚时文件放回上传目录，修改后可以再次导入
+func (j *importJob) importUploads() (importReport, error) {
+	if j.opts.DryRun {
+		uploads, err := listUploads(j.opts.Upload)
+		if err != nil {
+			return importReport{}, err
+		}
+		return j.run(uploads), nil
+	}
+	uploads, err := claimUploads(j.ID, j.opts.Upload)
+	if err != nil {
+		return importReport{}, err
+	}
+	report := j.run(uploads)
+	if report.RolledBack {
+		if err := releaseUploads(uploads); err != nil {
+			return report, err
+		}
+	}
+	return report, os.RemoveAll(filepath.Join(importingDir, j.ID))
+}
+
+// respondImport 返回导入报告，原子模式回滚时返回400
+func respondImport(c *gin.Context, report importReport) {
+	if report.RolledBack {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": http.StatusBadRequest,
+			"msg":  "存在错误，未导入任何数据",
+			"data": report})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": report})
+}
+
+// importStream 直接从上传请求中流式读取CSV或XLSX文件并导入，不落地临时文件
+// 请求可以包含多个文件，每个文件单独识别表头；XLSX是压缩格式，需要在内存中读完整个文件
+func importStream(c *gin.Context) {
+	opts, err := bindImportOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有读取到文件"})
+		return
+	}
+	j := newImportJob(opts)
+	files := 0
+	report := j.runFeed(func() {
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				j.errorChan <- ParseError{Msg: fmt.Sprintf("读取上传数据失败：%v", err)}
+				return
+			}
+			if part.FileName() != "" {
+				files++
+				name := filepath.Base(part.FileName())
+				//流式读取时只能根据开头的一段内容判断编码，CSV和XLSX使用相同的大小限制
+				limited := newLimitedReader(part, maxUploadSize)
+				buffered := bufio.NewReaderSize(limited, 64<<10)
+				head, _ := buffered.Peek(64 << 10)
+				fileType, encoding, err := sniffUpload(head)
+				if j.opts.Encoding != "" {
+					encoding = j.opts.Encoding
+				}
+				//读完整个文件才知道是否超过大小限制，先暂存解析出的行，超过限制时与上传一样整个文件不导入
+				j.holding = true
+				switch {
+				case err != nil:
+					j.errorChan <- ParseError{File: name, Msg: err.Error()}
+				case fileType == uploadXLSX:
+					j.parseXLSX(name, buffered)
+				default:
+					j.parseReader(name, decodeReader(buffered, encoding))
+				}
+				held := j.held
+				j.holding, j.held = false, nil
+				if !limited.exceeded() {
+					for _, row := range held {
+						j.dispatch(row)
+					}
+				}
+			}
+			part.Close()
+		}
+	})
+	if files == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有读取到文件"})
+		return
+	}
+	respondImport(c, report)
+}
+
+func parseCSV(c *gin.Context) {
+	opts, err := bindImportOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	report, err := newImportJob(opts).importUploads()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	respondImport(c, report)
+}
+
+func parseStudent(record []string, layout *csvLayout) (student, error) {
+	if layout.fixed && len(record) != 6 {
+		return student{}, &columnError{Msg: fmt.Sprintf("CSV文件格式错误，应为6列，实际%v列", len(record))}
+	}
+	number, numberColumn := layout.cell(record, fieldNumber)
+	number = strings.TrimSpace(number)
+	if number == "" {
+		return student{}, &columnError{Column: numberColumn, Msg: "学号不能为空"}
+	}
+	//依次获取结构体字段相应值
+	name, _ := layout.cell(record, fieldName)
+	age, _ := layout.cell(record, fieldAge)
+	sex, _ := layout.cell(record, fieldSex)
+	class, classColumn := layout.cell(record, fieldClass)
+	//班级是否归档要结合学生原来的班级判断，写入时再检查
+	if err := checkClassKnown(strings.TrimSpace(class)); err != nil {
+		return student{}, &columnError{Column: classColumn, Msg: err.Error()}
+	}
+	var scores map[string]grade
+	if layout.subjects != nil {
+		//宽表格式，每门课程一列，空白表示没有该课程成绩，按列顺序检查以便稳定地报告第一个出错的列
+		for _, col := range layout.subjects {
+			i, subject := col.index, col.subject
+			if i >= len(record) || strings.TrimSpace(record[i]) == "" {
+				continue
+			}
+			score, err := parseGrade(record[i])
+			if err != nil {
+				return student{}, &columnError{Column: i + 1, Msg: fmt.Sprintf("%v成绩无法识别：%v", subject, strings.TrimSpace(record[i]))}
+			}
+			subject, c, isComponent, err := canonicalScoreKey(subject)
+			if err == nil {
+				err = checkScoreKey(c, isComponent, score)
+			}
+			if err != nil {
+				return student{}, &columnError{Column: i + 1, Msg: err.Error()}
+			}
+			if _, dup := scores[subject]; dup {
+				return student{}, &columnError{Column: i + 1, Msg: fmt.Sprintf("课程%v重复", subject)}
+			}
+			if scores == nil {
+				scores = make(map[string]grade)
+			}
+			scores[subject] = score
+		}
+	} else {
+		//单独处理json格式字段，空白表示没有成绩
+		jsonString, scoreColumn := layout.cell(record, fieldScore)
+		jsonString = strings.Trim(jsonString, `"`)
+		if strings.TrimSpace(jsonString) != "" {
+			err := json.Unmarshal([]byte(jsonString), &scores)
+			if err != nil {
+				return student{}, &columnError{Column: scoreColumn, Msg: fmt.Sprintf("成绩格式错误：%v", err)}
+			}
+			scores, err = normalizeScores(scores)
+			if err != nil {
+				return student{}, &columnError{Column: scoreColumn, Msg: err.Error()}
+			}
+		}
+	}
+	computeTotals(scores)
+	//封装
+	student := student{
+		Name:   strings.TrimSpace(name),
+		Age:    strings.TrimSpace(age),
+		Sex:    strings.TrimSpace(sex),
+		Class:  strings.TrimSpace(class),
+		Number: number,
+		Scores: scores,
+	}
+	return student, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// importProgress 异步导入任务的进度，任务结束后附带完整的导入报告
+type importProgress struct {
+	ID         string        `json:"id"`
+	Status     string        `json:"status"` //running、finished 或 failed
+	Read       int           `json:"read"`
+	Imported   int           `json:"imported"`
+	Failed     int           `json:"failed"`
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Report     *importReport `json:"report,omitempty"`
+}
+
+// asyncImport 后台执行的导入任务
+type asyncImport struct {
+	job        *importJob
+	startedAt  time.Time
+	finishedAt time.Time
+	done       bool
+	err        error
+	report     importReport
+}
+
+var (
+	jobsMu     sync.Mutex
+	importJobs = make(map[string]*asyncImport) //所有导入任务，结束后保留一段时间以便查询报告
+)
+
+// 已结束任务的保留策略，超过保留时间或数量上限的任务被清理，运行中的任务不受影响
+var (
+	jobRetention    = time.Hour
+	maxFinishedJobs = 100
+)
+
+// pruneImportJobs 清理超过保留时间的已结束任务，数量仍超过上限时从最早结束的开始清理，调用方需持有jobsMu
+func pruneImportJobs(now time.Time) {
+	var finished []*asyncImport
+	for id, a := range importJobs {
+		if !a.done {
+			continue
+		}
+		if now.Sub(a.finishedAt) > jobRetention {
+			delete(importJobs, id)
+			continue
+		}
+		finished = append(finished, a)
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool {
+		return finished[a].finishedAt.Before(finished[b].finishedAt)
+	})
+	for _, a := range finished[:len(finished)-maxFinishedJobs] {
+		delete(importJobs, a.job.ID)
+	}
+}
+
+// newID 生成随机的十六进制标识
+func newID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func (a *asyncImport) progress() importProgress {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	p := importProgress{
+		ID:        a.job.ID,
+		Status:    "running",
+		Read:      int(a.job.read.Load()),
+		Imported:  int(a.job.imported.Load()),
+		Failed:    int(a.job.failed.Load()),
+		StartedAt: a.startedAt,
+	}
+	if a.done {
+		finishedAt := a.finishedAt
+		p.FinishedAt = &finishedAt
+		if a.err != nil {
+			p.Status = "failed"
+			p.Error = a.err.Error()
+		} else {
+			p.Status = "finished"
+			report := a.report
+			p.Report = &report
+		}
+	}
+	return p
+}
+
+// startImportJob 在后台导入上传目录中的文件，立即返回任务ID
+func startImportJob(c *gin.Context) {
+	opts, err := bindImportOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	a := &asyncImport{job: newImportJob(opts), startedAt: time.Now()}
+	jobsMu.Lock()
+	pruneImportJobs(a.startedAt)
+	importJobs[a.job.ID] = a
+	jobsMu.Unlock()
+	go func() {
+		report, err := a.job.importUploads()
+		jobsMu.Lock()
+		defer jobsMu.Unlock()
+		a.report, a.err = report, err
+		a.finishedAt = time.Now()
+		a.done = true
+	}()
+	c.JSON(http.StatusAccepted, gin.H{
+		"code": http.StatusAccepted,
+		"msg":  "任务已创建",
+		"data": gin.H{"id": a.job.ID},
+	})
+}
+
+// getImportJob 查询导入任务的进度和报告
+func getImportJob(c *gin.Context) {
+	jobsMu.Lock()
+	pruneImportJobs(time.Now())
+	a, ok := importJobs[c.Param("id")]
+	jobsMu.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": a.progress(),
+	})
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 列表分页大小
+const (
+	listDefaultLimit = 20
+	listMaxLimit     = 200
+)
+
+// 排序字段，按课程成绩排序时写作 score:课程名
+const (
+	sortTotal       = "total" //按总分排序
+	sortScorePrefix = "score:"
+)
+
+// listQuery 学生列表的查询参数，所有条件同时满足才会返回
+type listQuery struct {
+	Class        string   `form:"class"`
+	Sex          string   `form:"sex"`
+	MinAge       *int     `form:"minAge"`
+	MaxAge       *int     `form:"maxAge"`
+	Name         string   `form:"name"`         //姓名包含该内容，全部为英文字母时也按拼音首字母匹配，如 zs 匹配张三
+	Subject      []string `form:"subject"`      //有这些课程的成绩
+	ScoreSubject string   `form:"scoreSubject"` //成绩范围针对的课程，为空时针对总分
+	MinScore     *float64 `form:"minScore"`     //只比较数值成绩，等级和缺考等成绩视为没有成绩
+	MaxScore     *float64 `form:"maxScore"`
+	Sort         string   `form:"sort"`  //number（默认）、name、age、sex、class、total 或 score:课程名
+	Order        string   `form:"order"` //asc（默认）或 desc
+	Limit        int      `form:"limit"`
+	Cursor       string   `form:"cursor"` //上一页返回的nextCursor
+}
+
+// listKey 学生在当前排序下的位置，同时作为翻页游标的内容
+// 排序值相同时按学号排序，保证顺序稳定；没有排序值的学生（如缺少该课程成绩）总是排在最后
+type listKey struct {
+	Missing bool    `json:"m,omitempty"`
+	Num     float64 `json:"v,omitempty"`
+	Str     string  `json:"s,omitempty"`
+	Number  string  `json:"n"`
+}
+
+// listPage 一页查询结果
+type listPage struct {
+	Total      int        `json:"total"` //满足条件的学生总数
+	Items      []*student `json:"items"`
+	NextCursor string     `json:"nextCursor,omitempty"` //为空表示没有下一页
+}
+
+// validate 检查查询参数并填充默认值
+func (q *listQuery) validate() error {
+	switch {
+	case q.Sort == "":
+		q.Sort = fieldNumber
+	case q.Sort == fieldNumber, q.Sort == fieldName, q.Sort == fieldAge, q.Sort == fieldSex, q.Sort == fieldClass, q.Sort == sortTotal:
+	case strings.HasPrefix(q.Sort, sortScorePrefix) && len(q.Sort) > len(sortScorePrefix):
+	default:
+		return fmt.Errorf("未知的排序字段：%s", q.Sort)
+	}
+	switch q.Order {
+	case "":
+		q.Order = "asc"
+	case "asc", "desc":
+	default:
+		return fmt.Errorf("未知的排序方向：%s", q.Order)
+	}
+	if q.Limit <= 0 {
+		q.Limit = listDefaultLimit
+	}
+	q.Limit = min(q.Limit, listMaxLimit)
+	//课程可以使用代码或别名，与成绩的键比较前统一为注册表中的名称
+	var err error
+	for i, subject := range q.Subject {
+		if q.Subject[i], _, _, err = canonicalScoreKey(subject); err != nil {
+			return err
+		}
+	}
+	if q.ScoreSubject != "" {
+		if q.ScoreSubject, _, _, err = canonicalScoreKey(q.ScoreSubject); err != nil {
+			return err
+		}
+	}
+	if subject, ok := strings.CutPrefix(q.Sort, sortScorePrefix); ok {
+		if subject, _, _, err = canonicalScoreKey(subject); err != nil {
+			return err
+		}
+		q.Sort = sortScorePrefix + subject
+	}
+	return nil
+}
+
+// match 判断学生是否满足全部筛选条件
+func (q *listQuery) match(stu *student) bool {
+	if q.Class != "" && stu.Class != q.Class {
+		return false
+	}
+	if q.Sex != "" && stu.Sex != q.Sex {
+		return false
+	}
+	if q.MinAge != nil || q.MaxAge != nil {
+		age, err := strconv.Atoi(stu.Age)
+		if err != nil || q.MinAge != nil && age < *q.MinAge || q.MaxAge != nil && age > *q.MaxAge {
+			return false
+		}
+	}
+	if q.Name != "" && !matchName(stu.Name, q.Name) {
+		return false
+	}
+	for _, subject := range q.Subject {
+		if _, ok := stu.Scores[subject]; !ok {
+			return false
+		}
+	}
+	if q.MinScore != nil || q.MaxScore != nil {
+		score, ok := totalScore(stu), true
+		if q.ScoreSubject != "" {
+			g, exists := stu.Scores[q.ScoreSubject]
+			score, ok = g.numeric()
+			ok = ok && exists
+		}
+		if !ok || q.MinScore != nil && score < *q.MinScore || q.MaxScore != nil && score > *q.MaxScore {
+			return false
+		}
+	}
+	return true
+}
+
+// totalScore 当前学期各课程数值总评的总分，考核项不计入
+func totalScore(stu *student) float64 {
+	return sumScores(stu.Scores)
+}
+
+// sumScores 按课程名称顺序累加并保留两位小数，同样的成绩总能得到同样的总分，排序和并列名次不受map遍历顺序影响
+func sumScores(scores map[string]grade) float64 {
+	keys := make([]string, 0, len(scores))
+	for key := range scores {
+		if !isComponentKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	total := 0.0
+	for _, key := range keys {
+		if score, ok := scores[key].numeric(); ok {
+			total += score
+		}
+	}
+	return round2(total)
+}
+
+// key 计算学生在当前排序字段下的位置
+func (q *listQuery) key(stu *student) listKey {
+	key := listKey{Number: stu.Number}
+	switch {
+	case q.Sort == fieldNumber:
+	case q.Sort == fieldName:
+		key.Str = stu.Name
+	case q.Sort == fieldSex:
+		key.Str = stu.Sex
+	case q.Sort == fieldClass:
+		key.Str = stu.Class
+	case q.Sort == fieldAge:
+		age, err := strconv.Atoi(stu.Age)
+		key.Num, key.Missing = float64(age), err != nil
+	case q.Sort == sortTotal:
+		key.Num = totalScore(stu)
+	default:
+		g, ok := stu.Scores[strings.TrimPrefix(q.Sort, sortScorePrefix)]
+		score, numeric := g.numeric()
+		key.Num, key.Missing = score, !ok || !numeric
+	}
+	return key
+}
+
+// less 判断a是否排在b之前
+func (q *listQuery) less(a, b listKey) bool {
+	if a.Missing != b.Missing {
+		return b.Missing
+	}
+	if !a.Missing && (a.Num != b.Num || a.Str != b.Str) {
+		if q.Order == "desc" {
+			a, b = b, a
+		}
+		if a.Num != b.Num {
+			return a.Num < b.Num
+		}
+		return a.Str < b.Str
+	}
+	return a.Number < b.Number
+}
+
+func encodeCursor(key listKey) string {
+	data, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (listKey, error) {
+	var key listKey
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &key)
+	}
+	if err != nil {
+		return listKey{}, fmt.Errorf("cursor不合法")
+	}
+	return key, nil
+}
+
+// queryStudents 按条件筛选、排序并取出游标之后的一页
+// 游标记录的是上一页最后一名学生的排序位置，翻页期间数据有增删时也不会重复或遗漏未变动的学生
+func queryStudents(q listQuery, after *listKey) (listPage, error) {
+	type entry struct {
+		key listKey
+		stu *student
+	}
+	var matched []entry
+	subjects := q.Subject
+	if q.ScoreSubject != "" && (q.MinScore != nil || q.MaxScore != nil) {
+		subjects = append(subjects, q.ScoreSubject)
+	}
+	mu.Lock()
+	err := rangeIndexed(q.Class, subjects, func(stu *student) bool {
+		if q.match(stu) {
+			matched = append(matched, entry{key: q.key(stu), stu: stu})
+		}
+		return true
+	})
+	mu.Unlock()
+	if err != nil {
+		return listPage{}, err
+	}
+	sort.Slice(matched, func(a, b int) bool {
+		return q.less(matched[a].key, matched[b].key)
+	})
+	page := listPage{Total: len(matched), Items: []*student{}}
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return q.less(*after, matched[i].key)
+		})
+	}
+	end := min(start+q.Limit, len(matched))
+	for _, e := range matched[start:end] {
+		page.Items = append(page.Items, e.stu)
+	}
+	if end < len(matched) {
+		page.NextCursor = encodeCursor(matched[end-1].key)
+	}
+	return page, nil
+}
+
+// listStudents 按条件分页查询学生
+func listStudents(c *gin.Context) {
+	var q listQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := q.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var after *listKey
+	if q.Cursor != "" {
+		key, err := decodeCursor(q.Cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		after = &key
+	}
+	page, err := queryStudents(q, after)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": page})
+}
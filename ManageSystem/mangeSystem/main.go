@@ -1,45 +1,82 @@
 package main
 
 import (
-	"encoding/csv"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"io"
-	"mime/multipart"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"reflect"
-	"strings"
 	"sync"
 )
 
 // 学生结构体
 type student struct {
-	Name   string         `json:"name" form:"name"`
-	Age    string         `json:"age" form:"age"`
-	Sex    string         `json:"sex" form:"sex"`
-	Class  string         `json:"class" form:"class"`
-	Number string         `json:"number" form:"number"`
-	Scores map[string]int `json:"score" form:"score"`
-}
-
-// ParseError 记录读取CSV文件错误的结构体
-type ParseError struct {
-	Line int
-	Msg  string
+	Name   string                      `json:"name" form:"name"`
+	Age    string                      `json:"age" form:"age"`
+	Sex    string                      `json:"sex" form:"sex"`
+	Class  string                      `json:"class" form:"class"`
+	Number string                      `json:"number" form:"number"`
+	Scores map[string]grade            `json:"score" form:"score"`           //当前学期的成绩
+	Terms  map[string]map[string]grade `json:"terms,omitempty" form:"terms"` //其他学期的成绩，键为学期名称
 }
 
 var (
-	students    = make(map[string]*student) //相当于数据库，存储所有学生信息
-	mu          sync.Mutex
-	wg          sync.WaitGroup
-	studentChan = make(chan student, 1000)    //存储读取文件时的数据
-	errorChan   = make(chan ParseError, 1000) //存储读取文件时产生的错误
+	students = make(map[string]*student) //内存存储使用的学生信息
+	mu       sync.Mutex
 )
 
 func main() {
+	storeKind := flag.String("store", "memory", "存储类型：memory、bolt 或 wal")
+	dbPath := flag.String("db", "students.db", "bolt存储的数据文件路径，wal存储的数据目录")
+	flag.IntVar(&snapshotEvery, "snapshot-every", snapshotEvery, "wal存储每写入多少条日志生成快照")
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", snapshotInterval, "wal存储定期生成快照的间隔")
+	aliasPath := flag.String("csv-aliases", "", "CSV表头别名配置文件（JSON）")
+	gpaPath := flag.String("gpa-scales", "", "自定义绩点规则文件（JSON）")
+	termPath := flag.String("term-file", "", "保存当前学期的文件（JSON），为空时bolt和wal存储保存在数据旁，内存存储按日期推算当前学期")
+	classPath := flag.String("classes", "", "班级注册表文件（JSON），为空时班级只保存在内存中")
+	coursePath := flag.String("courses", "", "课程注册表文件（JSON），为空时课程只保存在内存中")
+	flag.Int64Var(&maxUploadSize, "max-upload", maxUploadSize, "单个上传文件的最大字节数")
+	flag.Parse()
+	if *aliasPath != "" {
+		if err := loadColumnAliases(*aliasPath); err != nil {
+			log.Fatalf("读取表头别名失败：%v", err)
+		}
+	}
+	if *coursePath != "" {
+		if err := loadCourses(*coursePath); err != nil {
+			log.Fatalf("读取课程失败：%v", err)
+		}
+	}
+	if *gpaPath != "" {
+		if err := loadGPAScales(*gpaPath); err != nil {
+			log.Fatalf("读取绩点规则失败：%v", err)
+		}
+	}
+	if *classPath != "" {
+		if err := loadClasses(*classPath); err != nil {
+			log.Fatalf("读取班级失败：%v", err)
+		}
+	}
+	s, err := newStore(*storeKind, *dbPath)
+	if err != nil {
+		log.Fatalf("初始化存储失败：%v", err)
+	}
+	store = s
+	defer store.Close()
+	//学期文件放在数据旁边，需在存储打开（wal存储创建数据目录）之后读取
+	if *termPath == "" {
+		*termPath = defaultTermFile(*storeKind, *dbPath)
+	}
+	if *termPath != "" {
+		if err := loadTerm(*termPath); err != nil {
+			log.Fatalf("读取学期失败：%v", err)
+		}
+	}
+	if err := migrateScoreKeys(); err != nil {
+		log.Fatalf("统一成绩中的课程名称失败：%v", err)
+	}
+
 	r := gin.Default()
 	studentGroup := r.Group("/student")
 	{
@@ -50,190 +87,99 @@ func main() {
 		studentGroup.PUT("/updateStudent", updateStudent)    //更新学生信息
 		studentGroup.GET("/getStudent", getStudent)          //根据学号查询基本信息和所有成绩信息
 		studentGroup.GET("/getScore", getScore)              //根据学号和课程名称查询特定课程的信息
+		studentGroup.GET("/list", listStudents)              //按条件分页查询学生
+		studentGroup.GET("/transcript", getTranscript)       //查询成绩单和各学期、累计绩点
 	}
-	CSVGroup := r.Group("/csv")
+	courseGroup := r.Group("/course")
 	{
-		CSVGroup.POST("/postFile", postFile)     //上传CSV文件
-		CSVGroup.POST("/parseStudent", parseCSV) //读取CSV文件
+		courseGroup.POST("/addCourse", addCourse)         //添加课程
+		courseGroup.PUT("/updateCourse", updateCourse)    //根据课程代码修改课程，改名时成绩随之迁移
+		courseGroup.DELETE("/deleteCourse", deleteCourse) //删除没有成绩的课程
+		courseGroup.GET("/getCourse", getCourse)          //根据代码、名称或别名查询课程
+		courseGroup.GET("/listCourses", listCourses)      //列出全部课程
 	}
-
-	err := r.Run()
-	if err != nil {
-		return
+	classGroup := r.Group("/class")
+	{
+		classGroup.POST("/addClass", addClass)         //创建班级
+		classGroup.PUT("/updateClass", updateClass)    //修改班级名称、班主任或归档，改名时学生随之修改
+		classGroup.GET("/listClasses", listClasses)    //列出班级
+		classGroup.GET("/roster", getRoster)           //查询班级学生名单
+		classGroup.POST("/moveStudents", moveStudents) //把学生转到指定班级
 	}
-}
-
-func parseCSV(c *gin.Context) {
-	//读取目录下的文件
-	dir, err := os.ReadDir("./postFile")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	termGroup := r.Group("/term")
+	{
+		termGroup.GET("/current", getTerm)               //查询当前学期
+		termGroup.POST("/rollover", rolloverTermHandler) //切换到新学期，当前成绩归入原学期
 	}
-	for _, file := range dir {
-		fmt.Println(file.Name())
-		parseFile("postFile/" + file.Name())
-		//删除已读的文件，防止后续文件重名的问题
-		err := os.Remove("postFile/" + file.Name())
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		}
+	reportGroup := r.Group("/report")
+	{
+		reportGroup.GET("/statistics", getStatistics) //按班级和课程统计成绩
+		reportGroup.GET("/ranking", getRanking)       //按总分或单科成绩排名
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"code": http.StatusOK,
-		"msg":  "操作成功",
-		"data": ""})
-}
-
-// 读取CSV文件，导入学生信息
-func parseFile(filePath string) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		errorChan <- ParseError{Line: -1, Msg: fmt.Sprintf("无法打开文件：%v", err)}
-		return
-	}
-	defer file.Close()
-	reader := csv.NewReader(file)
-	//通过goroutine开启多线程
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for lineNumber := 2; ; lineNumber++ {
-			record, err := reader.Read()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				errorChan <- ParseError{Line: lineNumber, Msg: fmt.Sprintf("第%v行读取数据有误", lineNumber)}
-				continue
-			}
-			//封装结构体数据
-			student, err := parseStudent(record)
-			if err != nil {
-				errorChan <- ParseError{Line: lineNumber, Msg: fmt.Sprintf("解析失败%v", err)}
-				continue
-			}
-			studentChan <- student
-		}
-		close(studentChan)
-	}()
-	fmt.Println(studentChan)
-	numWorkers := 10
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go worker(i)
+	CSVGroup := r.Group("/csv")
+	{
+		CSVGroup.POST("/postFile", postFile)          //上传CSV文件
+		CSVGroup.GET("/uploads", getUploads)          //列出等待导入的文件
+		CSVGroup.DELETE("/uploads/:id", deleteUpload) //删除等待导入的文件
+		CSVGroup.POST("/parseStudent", parseCSV)      //读取CSV文件
+		CSVGroup.POST("/jobs", startImportJob)        //后台导入已上传的CSV文件
+		CSVGroup.GET("/jobs/:id", getImportJob)       //查询导入任务进度
+		CSVGroup.POST("/import", importStream)        //上传并直接导入CSV文件
+		CSVGroup.GET("/export", exportStudents)       //导出学生和成绩
 	}
 
-	wg.Wait()
-	close(errorChan)
-	fmt.Println(students)
-}
-
-func worker(id int) {
-	//从通道读取结构体数值并存储到map中，使用sync保证原子性
-	defer wg.Done()
-	for student := range studentChan {
-		mu.Lock()
-		students[student.Number] = &student
-		mu.Unlock()
+	err = r.Run()
+	if err != nil {
+		return
 	}
 }
 
-func parseStudent(record []string) (student, error) {
-	if len(record) != 6 {
-		return student{}, fmt.Errorf("CSV文件格式错误")
+func getScore(c *gin.Context) {
+	//从请求头中获取参数
+	number := c.Query("number")
+	lessonName := c.Query("lessonName")
+	if lessonName == "" || number == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "信息输入不完全",
+		})
+		return
 	}
-	number := strings.TrimSpace(record[4])
-	if number == "" {
-		return student{}, fmt.Errorf("学号不能为空")
-	}
-	//依次获取结构体字段相应值
-	name := strings.TrimSpace(record[0])
-	age := strings.TrimSpace(record[1])
-	sex := strings.TrimSpace(record[2])
-	class := strings.TrimSpace(record[3])
-	//单独处理json格式字段
-	jsonString := strings.Trim(record[5], `"`)
-	var scores map[string]int
-	err := json.Unmarshal([]byte(jsonString), &scores)
-	if err != nil {
-		return student{}, err
-	}
-	//封装
-	student := student{
-		Name:   name,
-		Age:    age,
-		Sex:    sex,
-		Class:  class,
-		Number: number,
-		Scores: scores,
-	}
-	return student, nil
-}
-
-func postFile(c *gin.Context) {
-	// 确保请求中有文件上传
-	file, header, err := c.Request.FormFile("file")
+	lessonName, _, _, err := canonicalScoreKey(lessonName)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "没有读取到文件"})
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
 		return
 	}
 	mu.Lock()
 	defer mu.Unlock()
-	defer func(file multipart.File) {
-		err := file.Close()
-		if err != nil {
-			return
-		}
-	}(file)
-	// 创建保存文件的目录
-	uploadDir := "./postFile"
-	if _, err := os.Stat(uploadDir); os.IsNotExist(err) {
-		err := os.Mkdir(uploadDir, 0755)
-		if err != nil {
-			return
-		}
-	}
-	// 构建保存文件的完整路径
-	dst, err := os.Create(filepath.Join(uploadDir, header.Filename))
+	stu, exists, err := store.Get(number)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法构建文件"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer func(dst *os.File) {
-		err := dst.Close()
-		if err != nil {
-			return
-		}
-	}(dst)
-	// 复制文件内容到服务器
-	if _, err := io.Copy(dst, file); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "上传失败"})
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "学生不存在",
+		})
 		return
 	}
-	// 返回成功响应
-	c.JSON(http.StatusOK, gin.H{
-		"code": http.StatusOK,
-		"msg":  "上传成功",
-		"data": ""})
-}
-
-func getScore(c *gin.Context) {
-	//从请求头中获取参数
-	number := c.Query("number")
-	lessonName := c.Query("lessonName")
-	if lessonName == "" || number == "" {
+	term, err := queryTerm(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"code":    400,
-			"message": "信息输入不完全",
+			"message": err.Error(),
 		})
+		return
 	}
-	mu.Lock()
-	defer mu.Unlock()
-	if _, ok := students[number].Scores[lessonName]; ok {
+	if score, ok := termScoresOf(stu, term)[lessonName]; ok {
 		c.JSON(http.StatusOK, gin.H{
 			"code": http.StatusOK,
 			"msg":  "操作成功",
-			"data": students[number].Scores[lessonName],
+			"data": score,
 		})
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -252,14 +198,42 @@ func updateStudent(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	scores, err := normalizeScores(updateData.Scores)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	computeTotals(scores)
+	updateData.Scores = scores
+	terms, err := normalizeTerms(updateData.Terms)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updateData.Terms = terms
 	mu.Lock()
 	defer mu.Unlock()
 	//判断是否已存在
-	studentPtr, exists := students[number]
+	studentPtr, exists, err := store.Get(number)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "学生不存在"})
 		return
 	}
+	if err := checkCurrentTermLocked(updateData.Terms); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	//转班时目标班级必须存在且未归档
+	if updateData.Class != "" && updateData.Class != studentPtr.Class {
+		if err := checkClass(updateData.Class); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	// 使用反射来更新结构体字段
 	v1 := reflect.ValueOf(updateData)
@@ -276,9 +250,15 @@ func updateStudent(c *gin.Context) {
 		}
 	}
 	//若更新学号，则删除原来的学号数据
-	if updateData.Number != "" {
-		students[updateData.Number] = studentPtr
-		delete(students, number)
+	if studentPtr.Number != number {
+		if err := store.Delete(number); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := store.Put(studentPtr); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"msg":  "操作成功",
@@ -297,31 +277,59 @@ func deleteScore(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "未输入学号或学号为空"})
 		return
 	}
+	for i, v := range scores {
+		name, _, _, err := canonicalScoreKey(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		scores[i] = name
+	}
 	mu.Lock()
 	defer mu.Unlock()
-	if _, ok := students[number]; ok {
-		for _, v := range scores {
-			_, ok := students[number].Scores[v]
-			if ok {
-				delete(students[number].Scores, v)
-				c.JSON(http.StatusOK, gin.H{
-					"code": http.StatusOK,
-					"msg":  "操作成功",
-					"data": " ",
-				})
-			} else {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error": "该学生不存在此科目的成绩",
-					"科目":    v,
-				})
-				return
-			}
-		}
-	} else {
+	stu, exists, err := store.Get(number)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "学生不存在"})
 		return
 	}
-
+	term, err := queryTerm(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	termScores := termScoresOf(stu, term)
+	//先检查所有科目，避免只删除了一部分
+	for _, v := range scores {
+		if _, ok := termScores[v]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "该学生不存在此科目的成绩",
+				"科目":    v,
+			})
+			return
+		}
+	}
+	for _, v := range scores {
+		delete(termScores, v)
+	}
+	if err := checkComputedTotals(termScores, scores); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	computeTotals(termScores)
+	setTermScores(stu, term, termScores)
+	if err := store.Put(stu); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": " ",
+	})
 }
 
 func deleteStudent(c *gin.Context) {
@@ -334,8 +342,16 @@ func deleteStudent(c *gin.Context) {
 	mu.Lock()
 	defer mu.Unlock()
 	//存在则进行删除操作，不存在则返回错误
-	if _, ok := students[number]; ok {
-		delete(students, number)
+	_, exists, err := store.Get(number)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if exists {
+		if err := store.Delete(number); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"msg":  "操作成功",
 			"data": " ",
@@ -348,23 +364,66 @@ func deleteStudent(c *gin.Context) {
 
 func addOrUpdateScore(c *gin.Context) {
 	//从请求头中获取参数
-	var scores map[string]int
+	var scores map[string]grade
 	if err := c.ShouldBind(&scores); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	//指定component时请求中的成绩都是该考核项的成绩，如 {"数学":85} 表示 数学.期中
+	if comp := c.Query("component"); comp != "" {
+		componentScores := make(map[string]grade, len(scores))
+		for subject, score := range scores {
+			key, err := componentScoreKey(subject, comp)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			componentScores[key] = score
+		}
+		scores = componentScores
+	}
+	scores, err := normalizeScores(scores)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	number := c.Query("number")
-	if students[number] != nil {
+	stu, exists, err := store.Get(number)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if exists {
+		term, err := queryTerm(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		//原来没有这一科目成绩就新增科目及成绩
-		if students[number].Scores == nil {
-			students[number].Scores = scores
+		termScores := termScoresOf(stu, term)
+		if termScores == nil {
+			termScores = scores
 		} else {
 			for k, v := range scores {
-				students[number].Scores[k] = v
+				termScores[k] = v
 			}
 		} //存在就更新
+		keys := make([]string, 0, len(scores))
+		for k := range scores {
+			keys = append(keys, k)
+		}
+		if err := checkComputedTotals(termScores, keys); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		computeTotals(termScores)
+		setTermScores(stu, term, termScores)
+		if err := store.Put(stu); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"msg":  "操作成功",
 			"data": "",
@@ -386,6 +445,21 @@ func addStudent(c *gin.Context) {
 		})
 		return
 	}
+	scores, err := normalizeScores(stu.Scores)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	computeTotals(scores)
+	stu.Scores = scores
+	if stu.Terms, err = normalizeTerms(stu.Terms); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkClass(stu.Class); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	if stu.Number == "" {
@@ -394,10 +468,17 @@ func addStudent(c *gin.Context) {
 		})
 		return
 	}
-	students[stu.Number] = &stu
+	if err := checkCurrentTermLocked(stu.Terms); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := store.Put(&stu); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"msg":  "操作成功",
-		"data": students[stu.Number],
+		"data": &stu,
 	})
 }
 
@@ -405,15 +486,28 @@ func getStudent(c *gin.Context) {
 	number := c.Query("number")
 	mu.Lock()
 	defer mu.Unlock()
-	student := students[number]
-	if student == nil {
+	student, exists, err := store.Get(number)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"message": "该学生不存在"})
-		fmt.Println(students)
-	} else {
-		c.JSON(http.StatusOK, gin.H{
-			"code":    http.StatusOK,
-			"msg":     "操作成功",
-			"student": student})
-		fmt.Println(students)
+		return
+	}
+	response := gin.H{
+		"code":    http.StatusOK,
+		"msg":     "操作成功",
+		"student": student}
+	//transcript=true时附带各学期的成绩单，指定term时只附带该学期
+	if term := c.Query("term"); term != "" {
+		if !validTerm(term) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("学期名称不合法：%s", term)})
+			return
+		}
+		response["transcript"] = []termScores{{Term: term, Scores: termScoresOf(student, term)}}
+	} else if c.Query("transcript") == "true" {
+		response["transcript"] = transcript(student)
 	}
+	c.JSON(http.StatusOK, response)
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"strings"
+	"unicode"
+)
+
+// GB2312一级汉字按拼音排序，以下是各声母第一个汉字的区位码，用于在没有拼音词库时推算汉字的拼音首字母
+// 二级汉字按部首排序，无法推算，这类字不参与首字母匹配
+var pinyinBoundaries = []struct {
+	code   int
+	letter byte
+}{
+	{0xB0A1, 'a'}, {0xB0C5, 'b'}, {0xB2C1, 'c'}, {0xB4EE, 'd'}, {0xB6EA, 'e'},
+	{0xB7A2, 'f'}, {0xB8C1, 'g'}, {0xB9FE, 'h'}, {0xBBF7, 'j'}, {0xBFA6, 'k'},
+	{0xC0AC, 'l'}, {0xC2E8, 'm'}, {0xC4C3, 'n'}, {0xC5B6, 'o'}, {0xC5BE, 'p'},
+	{0xC6DA, 'q'}, {0xC8BB, 'r'}, {0xC8F6, 's'}, {0xCBFA, 't'}, {0xCDDA, 'w'},
+	{0xCEF4, 'x'}, {0xD1B9, 'y'}, {0xD4D1, 'z'},
+}
+
+const pinyinLastCode = 0xD7F9 //一级汉字的最后一个
+
+// pinyinInitial 返回汉字的拼音首字母，无法推算时返回0
+func pinyinInitial(r rune) byte {
+	encoded, err := simplifiedchinese.GBK.NewEncoder().String(string(r))
+	if err != nil || len(encoded) != 2 {
+		return 0
+	}
+	code := int(encoded[0])<<8 | int(encoded[1])
+	if code < pinyinBoundaries[0].code || code > pinyinLastCode {
+		return 0
+	}
+	letter := pinyinBoundaries[0].letter
+	for _, b := range pinyinBoundaries {
+		if code < b.code {
+			break
+		}
+		letter = b.letter
+	}
+	return letter
+}
+
+// pinyinInitials 把姓名转换为拼音首字母，如“张三”转换为“zs”，英文字母转为小写保留，其他字符用空格占位
+func pinyinInitials(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		switch {
+		case r < unicode.MaxASCII && unicode.IsLetter(r):
+			sb.WriteRune(unicode.ToLower(r))
+		case pinyinInitial(r) != 0:
+			sb.WriteByte(pinyinInitial(r))
+		default:
+			sb.WriteByte(' ')
+		}
+	}
+	return sb.String()
+}
+
+// matchName 判断姓名是否包含查询内容，查询内容全部是英文字母时也按拼音首字母匹配
+func matchName(name, query string) bool {
+	if strings.Contains(name, query) {
+		return true
+	}
+	for _, r := range query {
+		if r >= unicode.MaxASCII || !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return strings.Contains(pinyinInitials(name), strings.ToLower(query))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+)
+
+// 并列时的排名方式
+const (
+	rankStandard = "standard" //标准竞争排名，并列之后跳过名次，如 1、2、2、4
+	rankDense    = "dense"    //密集排名，并列之后不跳过名次，如 1、2、2、3
+)
+
+// rankingQuery 排名的查询参数
+type rankingQuery struct {
+	Class   string `form:"class"`   //只在该班级内排名，为空时在全部学生中排名
+	Subject string `form:"subject"` //按该课程的成绩排名，为空时按总分排名
+	Term    string `form:"term"`    //为空时使用当前学期
+	Method  string `form:"method"`  //standard（默认）或 dense
+	Number  string `form:"number"`  //只返回该学生的名次，名次仍在整个范围内计算
+}
+
+// rankEntry 一名学生的名次
+type rankEntry struct {
+	Number     string  `json:"number"`
+	Name       string  `json:"name"`
+	Class      string  `json:"class"`
+	Score      float64 `json:"score"`
+	Rank       int     `json:"rank"`
+	Percentile float64 `json:"percentile"` //分数低于该学生的人数占其他学生的百分比，第一名为100
+}
+
+// rankEntries 按分数从高到低排名，分数相同时名次相同并按学号排列
+func rankEntries(entries []rankEntry, method string) {
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].Score != entries[b].Score {
+			return entries[a].Score > entries[b].Score
+		}
+		return entries[a].Number < entries[b].Number
+	})
+	n := len(entries)
+	for i := range entries {
+		switch {
+		case i > 0 && entries[i].Score == entries[i-1].Score:
+			entries[i].Rank = entries[i-1].Rank
+		case method == rankDense && i > 0:
+			entries[i].Rank = entries[i-1].Rank + 1
+		default:
+			entries[i].Rank = i + 1
+		}
+	}
+	//从低到高统计分数更低的人数
+	below := 0
+	for i := n - 1; i >= 0; {
+		j := i
+		for j >= 0 && entries[j].Score == entries[i].Score {
+			j--
+		}
+		for k := j + 1; k <= i; k++ {
+			if n == 1 {
+				entries[k].Percentile = 100
+			} else {
+				entries[k].Percentile = round2(float64(below) / float64(n-1) * 100)
+			}
+		}
+		below += i - j
+		i = j
+	}
+}
+
+// getRanking 按总分或单科成绩在班级或全部学生中排名
+// 总分只计算数值成绩；按单科排名时没有该课程数值成绩的学生不参与排名
+func getRanking(c *gin.Context) {
+	var q rankingQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch q.Method {
+	case "":
+		q.Method = rankStandard
+	case rankStandard, rankDense:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("未知的排名方式：%s", q.Method)})
+		return
+	}
+	if q.Subject != "" {
+		name, _, err := canonicalSubject(q.Subject)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		q.Subject = name
+	}
+	var subjects []string
+	if q.Subject != "" {
+		subjects = []string{q.Subject}
+	}
+	entries := []rankEntry{}
+	mu.Lock()
+	term, err := queryTerm(c)
+	if err != nil {
+		mu.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	//其他学期的成绩没有索引，不能按课程缩小范围
+	if term != currentTerm {
+		subjects = nil
+	}
+	err = rangeIndexed(q.Class, subjects, func(stu *student) bool {
+		if q.Class != "" && stu.Class != q.Class {
+			return true
+		}
+		scores := termScoresOf(stu, term)
+		entry := rankEntry{Number: stu.Number, Name: stu.Name, Class: stu.Class}
+		if q.Subject == "" {
+			if len(scores) == 0 {
+				return true
+			}
+			entry.Score = sumScores(scores)
+		} else {
+			g, exists := scores[q.Subject]
+			score, ok := g.numeric()
+			if !exists || !ok {
+				return true
+			}
+			entry.Score = score
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	mu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rankEntries(entries, q.Method)
+	total := len(entries)
+	if q.Number != "" {
+		filtered := []rankEntry{}
+		for _, entry := range entries {
+			if entry.Number == q.Number {
+				filtered = append(filtered, entry)
+			}
+		}
+		if len(filtered) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "该学生不在排名范围内"})
+			return
+		}
+		entries = filtered
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": gin.H{"term": term, "total": total, "ranking": entries}})
+}
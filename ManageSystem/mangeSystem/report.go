@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// statsQuery 统计报表的查询参数
+type statsQuery struct {
+	Class     string   `form:"class"`     //只统计该班级，为空时统计全部班级
+	Subject   []string `form:"subject"`   //只统计这些课程，为空时统计全部课程
+	Term      string   `form:"term"`      //为空时统计当前学期
+	Bucket    float64  `form:"bucket"`    //分数段宽度，默认10分
+	Excellent float64  `form:"excellent"` //优秀线占满分的百分比，默认85
+}
+
+// 统计范围
+const (
+	scopeClass  = "class"  //一个班级的一门课程
+	scopeCohort = "cohort" //所选全部学生的一门课程
+)
+
+// maxBuckets 一门课程最多的分数段数量，避免分数段过窄时生成过大的结果
+const maxBuckets = 100
+
+// histogramBucket 分数段，包含Min，不包含Max，最后一段包含满分
+type histogramBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// subjectStats 一门课程在一个范围内的统计结果，只有数值成绩参与平均分等计算
+type subjectStats struct {
+	Scope         string            `json:"scope"`
+	Class         string            `json:"class,omitempty"`
+	Subject       string            `json:"subject"`
+	Count         int               `json:"count"`  //数值成绩的人数
+	Absent        int               `json:"absent"` //缺考和作弊的人数，计入及格率的分母
+	Average       float64           `json:"average"`
+	Median        float64           `json:"median"`
+	StdDev        float64           `json:"stdDev"` //总体标准差
+	Max           float64           `json:"max"`
+	Min           float64           `json:"min"`
+	PassRate      float64           `json:"passRate"`      //百分比
+	ExcellentRate float64           `json:"excellentRate"` //百分比
+	Histogram     []histogramBucket `json:"histogram"`
+}
+
+// gradeSample 统计时收集的一组成绩
+type gradeSample struct {
+	scores []float64
+	absent int
+}
+
+func (s *gradeSample) add(g grade) {
+	if score, ok := g.numeric(); ok {
+		s.scores = append(s.scores, score)
+	} else if g.Mark == markAbsent || g.Mark == markCheating {
+		s.absent++
+	}
+}
+
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// summarize 计算一组成绩的统计量，maxScore和passLine来自课程注册表
+func (s *gradeSample) summarize(maxScore, passLine int, excellent, bucket float64) subjectStats {
+	stats := subjectStats{Count: len(s.scores), Absent: s.absent, Histogram: []histogramBucket{}}
+	for lo := 0.0; lo < float64(maxScore); lo += bucket {
+		stats.Histogram = append(stats.Histogram, histogramBucket{Min: lo, Max: math.Min(lo+bucket, float64(maxScore))})
+	}
+	if stats.Count == 0 {
+		return stats
+	}
+	scores := append([]float64(nil), s.scores...)
+	sort.Float64s(scores)
+	sum, passed, excellentCount := 0.0, 0, 0
+	excellentLine := float64(maxScore) * excellent / 100
+	for _, score := range scores {
+		sum += score
+		if score >= float64(passLine) {
+			passed++
+		}
+		if score >= excellentLine {
+			excellentCount++
+		}
+		if len(stats.Histogram) > 0 {
+			i := int(score / bucket)
+			i = max(0, min(i, len(stats.Histogram)-1))
+			stats.Histogram[i].Count++
+		}
+	}
+	n := float64(len(scores))
+	mean := sum / n
+	variance := 0.0
+	for _, score := range scores {
+		variance += (score - mean) * (score - mean)
+	}
+	stats.Average = round2(mean)
+	stats.StdDev = round2(math.Sqrt(variance / n))
+	stats.Min, stats.Max = scores[0], scores[len(scores)-1]
+	if len(scores)%2 == 1 {
+		stats.Median = scores[len(scores)/2]
+	} else {
+		stats.Median = round2((scores[len(scores)/2-1] + scores[len(scores)/2]) / 2)
+	}
+	total := float64(len(scores) + s.absent)
+	stats.PassRate = round2(float64(passed) / total * 100)
+	stats.ExcellentRate = round2(float64(excellentCount) / total * 100)
+	return stats
+}
+
+// subjectScale 返回课程的满分和及格线，没有登记的课程按百分制、60分及格
+func subjectScale(subject string) (int, int) {
+	coursesMu.RLock()
+	defer coursesMu.RUnlock()
+	if code, ok := courseLookup[courseKey(subject)]; ok {
+		return courses[code].MaxScore, courses[code].PassLine
+	}
+	return 100, 60
+}
+
+// collectStats 按班级和课程收集成绩，键为班级名称和课程名称，调用方需持有mu
+func collectStats(q statsQuery, term string, subjects map[string]bool) (map[[2]string]*gradeSample, error) {
+	samples := make(map[[2]string]*gradeSample)
+	err := rangeIndexed(q.Class, nil, func(stu *student) bool {
+		if q.Class != "" && stu.Class != q.Class {
+			return true
+		}
+		for subject, g := range termScoresOf(stu, term) {
+			if isComponentKey(subject) || len(subjects) > 0 && !subjects[subject] {
+				continue
+			}
+			key := [2]string{stu.Class, subject}
+			if samples[key] == nil {
+				samples[key] = &gradeSample{}
+			}
+			samples[key].add(g)
+		}
+		return true
+	})
+	return samples, err
+}
+
+// getStatistics 按班级和课程统计平均分、中位数、标准差、最高最低分、及格率、优秀率和分数段
+// 每门课程先给出各班级的结果，再给出所选全部学生的结果
+func getStatistics(c *gin.Context) {
+	var q statsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if math.IsNaN(q.Bucket) || math.IsInf(q.Bucket, 0) || math.IsNaN(q.Excellent) || math.IsInf(q.Excellent, 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "分数段宽度和优秀线必须是有限的数字"})
+		return
+	}
+	if q.Bucket <= 0 {
+		q.Bucket = 10
+	}
+	if q.Excellent <= 0 {
+		q.Excellent = 85
+	}
+	if q.Excellent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "优秀线不能超过满分"})
+		return
+	}
+	subjects := make(map[string]bool)
+	for _, subject := range q.Subject {
+		name, _, err := canonicalSubject(subject)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		subjects[name] = true
+	}
+	mu.Lock()
+	term, err := queryTerm(c)
+	if err != nil {
+		mu.Unlock()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	samples, err := collectStats(q, term, subjects)
+	mu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cohort := make(map[string]*gradeSample)
+	keys := make([][2]string, 0, len(samples))
+	for key, sample := range samples {
+		keys = append(keys, key)
+		if cohort[key[1]] == nil {
+			cohort[key[1]] = &gradeSample{}
+		}
+		cohort[key[1]].scores = append(cohort[key[1]].scores, sample.scores...)
+		cohort[key[1]].absent += sample.absent
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a][1] != keys[b][1] {
+			return keys[a][1] < keys[b][1]
+		}
+		return keys[a][0] < keys[b][0]
+	})
+	result := make([]subjectStats, 0, len(keys)+len(cohort))
+	for i, key := range keys {
+		maxScore, passLine := subjectScale(key[1])
+		if math.Ceil(float64(maxScore)/q.Bucket) > maxBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("分数段过窄：%v最多分为%d段", key[1], maxBuckets)})
+			return
+		}
+		stats := samples[key].summarize(maxScore, passLine, q.Excellent, q.Bucket)
+		stats.Scope, stats.Class, stats.Subject = scopeClass, key[0], key[1]
+		result = append(result, stats)
+		//一门课程的各班级之后紧跟全部学生的结果
+		if i == len(keys)-1 || keys[i+1][1] != key[1] {
+			stats := cohort[key[1]].summarize(maxScore, passLine, q.Excellent, q.Bucket)
+			stats.Scope, stats.Subject = scopeCohort, key[1]
+			result = append(result, stats)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": gin.H{"term": term, "stats": result}})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// studentStore 学生数据存储接口，所有处理函数都通过它读写学生信息
+// 实现本身不加锁，调用方需持有mu
+type studentStore interface {
+	Get(number string) (*student, bool, error) //根据学号获取学生
+	Put(stu *student) error                    //新增或覆盖学生
+	PutAll(stus []*student) error              //在一次事务中写入多名学生，失败时不写入任何数据
+	Delete(number string) error                //根据学号删除学生
+	Range(fn func(stu *student) bool) error    //按学号顺序遍历，fn返回false时停止，fn不应修改stu
+	Close() error
+}
+
+var (
+	store            studentStore = newEmptyIndex(memoryStore{}) //当前使用的存储，默认是带索引的内存存储，启动时可通过参数切换
+	snapshotEvery                 = 1000                         //wal存储每写入多少条日志生成一次快照
+	snapshotInterval              = 5 * time.Minute
+)
+
+// newStore 根据启动参数创建存储并建立二级索引，path对bolt是数据文件，对wal是数据目录
+func newStore(kind, path string) (studentStore, error) {
+	var s studentStore
+	var err error
+	switch kind {
+	case "memory":
+		s = memoryStore{}
+	case "bolt":
+		s, err = openBoltStore(path)
+	case "wal":
+		s, err = openWALStore(path, snapshotEvery, snapshotInterval)
+	default:
+		return nil, fmt.Errorf("未知的存储类型：%s", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	indexed, err := newIndexedStore(s)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return indexed, nil
+}
+
+// clone 深拷贝学生信息，修改副本不会影响存储中的数据
+func (s *student) clone() *student {
+	c := *s
+	if s.Scores != nil {
+		c.Scores = make(map[string]grade, len(s.Scores))
+		for subject, score := range s.Scores {
+			c.Scores[subject] = score
+		}
+	}
+	if s.Terms != nil {
+		c.Terms = make(map[string]map[string]grade, len(s.Terms))
+		for term, scores := range s.Terms {
+			c.Terms[term] = make(map[string]grade, len(scores))
+			for subject, score := range scores {
+				c.Terms[term][subject] = score
+			}
+		}
+	}
+	return &c
+}
+
+// memoryStore 基于全局students的内存存储，进程退出后数据丢失
+// Get返回副本，与其他存储一样修改后需调用Put才会保存
+type memoryStore struct{}
+
+func (memoryStore) Get(number string) (*student, bool, error) {
+	stu, ok := students[number]
+	if !ok {
+		return nil, false, nil
+	}
+	return stu.clone(), true, nil
+}
+
+func (memoryStore) Put(stu *student) error {
+	students[stu.Number] = stu
+	return nil
+}
+
+func (memoryStore) PutAll(stus []*student) error {
+	for _, stu := range stus {
+		students[stu.Number] = stu
+	}
+	return nil
+}
+
+func (memoryStore) Delete(number string) error {
+	delete(students, number)
+	return nil
+}
+
+func (memoryStore) Range(fn func(stu *student) bool) error {
+	numbers := make([]string, 0, len(students))
+	for number := range students {
+		numbers = append(numbers, number)
+	}
+	sort.Strings(numbers)
+	for _, number := range numbers {
+		if !fn(students[number]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+var studentBucket = []byte("students")
+
+// boltStore 基于BoltDB文件的持久化存储，学生以JSON形式按学号保存
+type boltStore struct {
+	db *bbolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(studentBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Get(number string) (*student, bool, error) {
+	var stu *student
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(studentBucket).Get([]byte(number))
+		if data == nil {
+			return nil
+		}
+		stu = &student{}
+		return json.Unmarshal(data, stu)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return stu, stu != nil, nil
+}
+
+func (s *boltStore) Put(stu *student) error {
+	data, err := json.Marshal(stu)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(studentBucket).Put([]byte(stu.Number), data)
+	})
+}
+
+func (s *boltStore) PutAll(stus []*student) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(studentBucket)
+		for _, stu := range stus {
+			data, err := json.Marshal(stu)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(stu.Number), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Delete(number string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(studentBucket).Delete([]byte(number))
+	})
+}
+
+func (s *boltStore) Range(fn func(stu *student) bool) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(studentBucket).Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			stu := &student{}
+			if err := json.Unmarshal(v, stu); err != nil {
+				return err
+			}
+			if !fn(stu) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
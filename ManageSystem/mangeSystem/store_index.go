@@ -0,0 +1,185 @@
+package main
+
+import (
+	"sort"
+)
+
+// studentIndex 按班级和课程查找学号的二级索引，调用方需持有mu
+type studentIndex interface {
+	ByClass(class string) []string     //该班级全部学生的学号，按学号排序
+	BySubject(subject string) []string //有该课程成绩的全部学生的学号，按学号排序
+}
+
+// indexEntry 记录一名学生当前被索引的值，修改或删除时据此从旧的索引项中移除
+type indexEntry struct {
+	class    string
+	subjects []string
+}
+
+// indexedStore 在任意存储之上维护班级和课程的二级索引
+// 所有写入都经过存储接口，处理函数和导入任务不需要额外维护索引；索引只在内存中，启动时重建
+type indexedStore struct {
+	studentStore
+	classes  map[string]map[string]struct{} //班级到学号
+	subjects map[string]map[string]struct{} //课程到学号
+	entries  map[string]indexEntry          //学号到已索引的值
+}
+
+// newEmptyIndex 为空的存储创建索引，不遍历已有数据
+func newEmptyIndex(s studentStore) *indexedStore {
+	return &indexedStore{
+		studentStore: s,
+		classes:      make(map[string]map[string]struct{}),
+		subjects:     make(map[string]map[string]struct{}),
+		entries:      make(map[string]indexEntry),
+	}
+}
+
+// newIndexedStore 遍历s中的全部学生建立索引
+func newIndexedStore(s studentStore) (*indexedStore, error) {
+	idx := newEmptyIndex(s)
+	mu.Lock()
+	defer mu.Unlock()
+	err := s.Range(func(stu *student) bool {
+		idx.add(stu)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func (s *indexedStore) Put(stu *student) error {
+	if err := s.studentStore.Put(stu); err != nil {
+		return err
+	}
+	s.remove(stu.Number)
+	s.add(stu)
+	return nil
+}
+
+func (s *indexedStore) PutAll(stus []*student) error {
+	if err := s.studentStore.PutAll(stus); err != nil {
+		return err
+	}
+	for _, stu := range stus {
+		s.remove(stu.Number)
+		s.add(stu)
+	}
+	return nil
+}
+
+func (s *indexedStore) Delete(number string) error {
+	if err := s.studentStore.Delete(number); err != nil {
+		return err
+	}
+	s.remove(number)
+	return nil
+}
+
+func (s *indexedStore) ByClass(class string) []string {
+	return sortedNumbers(s.classes[class])
+}
+
+func (s *indexedStore) BySubject(subject string) []string {
+	return sortedNumbers(s.subjects[subject])
+}
+
+func (s *indexedStore) add(stu *student) {
+	entry := indexEntry{class: stu.Class}
+	addIndex(s.classes, stu.Class, stu.Number)
+	for subject := range stu.Scores {
+		entry.subjects = append(entry.subjects, subject)
+		addIndex(s.subjects, subject, stu.Number)
+	}
+	s.entries[stu.Number] = entry
+}
+
+func (s *indexedStore) remove(number string) {
+	entry, ok := s.entries[number]
+	if !ok {
+		return
+	}
+	removeIndex(s.classes, entry.class, number)
+	for _, subject := range entry.subjects {
+		removeIndex(s.subjects, subject, number)
+	}
+	delete(s.entries, number)
+}
+
+func addIndex(index map[string]map[string]struct{}, key, number string) {
+	set, ok := index[key]
+	if !ok {
+		set = make(map[string]struct{})
+		index[key] = set
+	}
+	set[number] = struct{}{}
+}
+
+// removeIndex 从索引项中移除学号，索引项为空时一并删除，避免已不存在的班级和课程残留
+func removeIndex(index map[string]map[string]struct{}, key, number string) {
+	set := index[key]
+	delete(set, number)
+	if len(set) == 0 {
+		delete(index, key)
+	}
+}
+
+func sortedNumbers(set map[string]struct{}) []string {
+	numbers := make([]string, 0, len(set))
+	for number := range set {
+		numbers = append(numbers, number)
+	}
+	sort.Strings(numbers)
+	return numbers
+}
+
+// rangeIndexed 只遍历指定班级且有全部指定课程成绩的学生，按学号顺序，调用方需持有mu
+// 存储没有索引或没有可用的条件时遍历全部学生，fn仍需自行检查条件
+// 课程索引只包含当前学期的成绩（Scores），按其他学期的成绩筛选时subjects应传nil
+func rangeIndexed(class string, subjects []string, fn func(stu *student) bool) error {
+	index, ok := store.(studentIndex)
+	if !ok || class == "" && len(subjects) == 0 {
+		return store.Range(fn)
+	}
+	var numbers []string
+	if class != "" {
+		numbers = index.ByClass(class)
+	}
+	for i, subject := range subjects {
+		if i == 0 && class == "" {
+			numbers = index.BySubject(subject)
+			continue
+		}
+		numbers = intersectNumbers(numbers, index.BySubject(subject))
+	}
+	for _, number := range numbers {
+		stu, exists, err := store.Get(number)
+		if err != nil {
+			return err
+		}
+		if exists && !fn(stu) {
+			break
+		}
+	}
+	return nil
+}
+
+// intersectNumbers 求两个已排序学号列表的交集
+func intersectNumbers(a, b []string) []string {
+	var result []string
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
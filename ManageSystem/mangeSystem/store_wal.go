@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.json"
+)
+
+// walRecord 预写日志中的一条记录，put记录保存修改后的完整学生信息，重放时直接覆盖
+type walRecord struct {
+	Op       string     `json:"op"` //put、batch 或 del
+	Number   string     `json:"number,omitempty"`
+	Student  *student   `json:"student,omitempty"`
+	Students []*student `json:"students,omitempty"` //batch记录一次写入的全部学生，整条记录要么完整要么被丢弃
+}
+
+// walStore 在内存存储的基础上增加预写日志和定期快照，启动时从快照和日志恢复数据
+type walStore struct {
+	memoryStore
+	dir           string
+	wal           *os.File
+	ops           int //上次快照之后写入日志的记录数
+	snapshotEvery int //日志记录数达到该值时生成快照，0表示不按数量触发
+	stop          chan struct{}
+}
+
+// openWALStore 打开dir下的快照和日志并恢复到students中，interval大于0时定期生成快照
+func openWALStore(dir string, snapshotEvery int, interval time.Duration) (*walStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &walStore{dir: dir, snapshotEvery: snapshotEvery, stop: make(chan struct{})}
+	mu.Lock()
+	defer mu.Unlock()
+	students = make(map[string]*student)
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.wal = wal
+	if interval > 0 {
+		go s.snapshotLoop(interval)
+	}
+	return s, nil
+}
+
+func (s *walStore) Put(stu *student) error {
+	if err := s.append(walRecord{Op: "put", Student: stu}); err != nil {
+		return err
+	}
+	_ = s.memoryStore.Put(stu)
+	s.maybeSnapshot()
+	return nil
+}
+
+func (s *walStore) PutAll(stus []*student) error {
+	if err := s.append(walRecord{Op: "batch", Students: stus}); err != nil {
+		return err
+	}
+	_ = s.memoryStore.PutAll(stus)
+	s.maybeSnapshot()
+	return nil
+}
+
+func (s *walStore) Delete(number string) error {
+	if err := s.append(walRecord{Op: "del", Number: number}); err != nil {
+		return err
+	}
+	_ = s.memoryStore.Delete(number)
+	s.maybeSnapshot()
+	return nil
+}
+
+func (s *walStore) Close() error {
+	close(s.stop)
+	mu.Lock()
+	defer mu.Unlock()
+	if err := s.snapshot(); err != nil {
+		return err
+	}
+	return s.wal.Close()
+}
+
+// append 写入一条日志并落盘，写入成功后才修改内存数据
+func (s *walStore) append(rec walRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.wal.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	s.ops++
+	return nil
+}
+
+// maybeSnapshot 日志记录数达到阈值时生成快照，需在内存数据修改之后调用
+func (s *walStore) maybeSnapshot() {
+	if s.snapshotEvery <= 0 || s.ops < s.snapshotEvery {
+		return
+	}
+	//快照失败不影响本次写入，日志仍然完整
+	if err := s.snapshot(); err != nil {
+		log.Printf("生成快照失败：%v", err)
+	}
+}
+
+// snapshot 把当前数据写入新快照并清空日志，调用方需持有mu
+// 先写临时文件再重命名，任何时刻崩溃都能由旧快照加日志或新快照恢复
+func (s *walStore) snapshot() error {
+	tmpPath := filepath.Join(s.dir, snapshotFileName+".tmp")
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	list := make([]*student, 0, len(students))
+	_ = s.memoryStore.Range(func(stu *student) bool {
+		list = append(list, stu)
+		return true
+	})
+	if err := json.NewEncoder(tmp).Encode(list); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(s.dir, snapshotFileName)); err != nil {
+		return err
+	}
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	s.ops = 0
+	return nil
+}
+
+func (s *walStore) snapshotLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			mu.Lock()
+			if s.ops > 0 {
+				if err := s.snapshot(); err != nil {
+					log.Printf("生成快照失败：%v", err)
+				}
+			}
+			mu.Unlock()
+		}
+	}
+}
+
+func (s *walStore) loadSnapshot() error {
+	file, err := os.Open(filepath.Join(s.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var list []*student
+	if err := json.NewDecoder(file).Decode(&list); err != nil {
+		return fmt.Errorf("快照文件损坏：%v", err)
+	}
+	for _, stu := range list {
+		students[stu.Number] = stu
+	}
+	return nil
+}
+
+// validate 检查日志记录是否完整，重放前调用，避免把损坏的记录写入内存
+func (rec *walRecord) validate() error {
+	switch rec.Op {
+	case "put":
+		if rec.Student == nil || rec.Student.Number == "" {
+			return errors.New("put记录缺少学生信息")
+		}
+	case "batch":
+		for _, stu := range rec.Students {
+			if stu == nil || stu.Number == "" {
+				return errors.New("batch记录缺少学生信息")
+			}
+		}
+	case "del":
+		if rec.Number == "" {
+			return errors.New("del记录缺少学号")
+		}
+	default:
+		return fmt.Errorf("未知的日志操作：%q", rec.Op)
+	}
+	return nil
+}
+
+// replay 按顺序重放日志，崩溃时写了一半的末尾记录会被截断丢弃
+// 中间的记录损坏时返回错误，不截断日志，避免丢失其后的有效记录
+func (s *walStore) replay() error {
+	path := filepath.Join(s.dir, walFileName)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var offset int64
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			//没有换行符的末尾记录没有写完
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var rec walRecord
+		err = json.Unmarshal(line, &rec)
+		if err == nil {
+			err = rec.validate()
+		}
+		if err != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				break
+			}
+			return fmt.Errorf("日志第%d条记录损坏：%v", lineNo, err)
+		}
+		switch rec.Op {
+		case "put":
+			students[rec.Student.Number] = rec.Student
+		case "batch":
+			for _, stu := range rec.Students {
+				students[stu.Number] = stu
+			}
+		case "del":
+			delete(students, rec.Number)
+		}
+		offset += int64(len(line))
+		s.ops++
+	}
+	return os.Truncate(path, offset)
+}
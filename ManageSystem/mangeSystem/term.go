@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 学期名称为“年份-季节”，如 2026-Fall，同一年内按季节顺序排列
+var (
+	termPattern = regexp.MustCompile(`^(\d{4})-(Spring|Summer|Fall|Winter)$`)
+	seasonOrder = map[string]int{"Spring": 0, "Summer": 1, "Fall": 2, "Winter": 3}
+)
+
+// 当前学期，学生的Scores保存当前学期的成绩，其他学期的成绩保存在Terms中
+// currentTerm由mu保护，只能通过学期切换修改
+var (
+	currentTerm = defaultTerm(time.Now())
+	termFile    string //当前学期保存的文件，为空时只保存在内存中，bolt和wal存储默认保存在数据文件旁
+)
+
+// defaultTermFile 持久化存储默认把当前学期保存在数据旁边，重启后成绩所属的学期不随日期变化
+// 内存存储的成绩不会保留，不需要保存学期
+func defaultTermFile(kind, path string) string {
+	switch kind {
+	case "bolt":
+		return path + ".term.json"
+	case "wal":
+		return filepath.Join(path, "term.json")
+	default:
+		return ""
+	}
+}
+
+// termState 学期文件的内容
+type termState struct {
+	Current string `json:"current"`
+}
+
+// termScores 一个学期的成绩单
+type termScores struct {
+	Term   string           `json:"term"`
+	Scores map[string]grade `json:"score"`
+}
+
+// defaultTerm 根据日期推算学期，2至7月为春季学期，其余为秋季学期，1月属于上一年的秋季学期
+func defaultTerm(now time.Time) string {
+	year := now.Year()
+	switch {
+	case now.Month() == time.January:
+		return fmt.Sprintf("%d-Fall", year-1)
+	case now.Month() <= time.July:
+		return fmt.Sprintf("%d-Spring", year)
+	default:
+		return fmt.Sprintf("%d-Fall", year)
+	}
+}
+
+func validTerm(term string) bool {
+	return termPattern.MatchString(term)
+}
+
+// termLess 按时间先后比较两个学期
+func termLess(a, b string) bool {
+	ma, mb := termPattern.FindStringSubmatch(a), termPattern.FindStringSubmatch(b)
+	if ma == nil || mb == nil {
+		return a < b
+	}
+	ya, _ := strconv.Atoi(ma[1])
+	yb, _ := strconv.Atoi(mb[1])
+	if ya != yb {
+		return ya < yb
+	}
+	return seasonOrder[ma[2]] < seasonOrder[mb[2]]
+}
+
+// loadTerm 从文件读取当前学期，文件不存在时按日期推算并写入，之后不再随日期变化
+func loadTerm(path string) error {
+	termFile = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return saveTerm()
+	}
+	if err != nil {
+		return err
+	}
+	var state termState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("学期文件损坏：%v", err)
+	}
+	if !validTerm(state.Current) {
+		return fmt.Errorf("学期名称不合法：%s", state.Current)
+	}
+	currentTerm = state.Current
+	return nil
+}
+
+func saveTerm() error {
+	return writeTerm(currentTerm)
+}
+
+// writeTerm 把term作为当前学期写入文件
+func writeTerm(term string) error {
+	if termFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(termState{Current: term})
+	if err != nil {
+		return err
+	}
+	tmpPath := termFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, termFile)
+}
+
+// queryTerm 读取请求中的学期参数，为空时表示当前学期，调用方需持有mu
+func queryTerm(c *gin.Context) (string, error) {
+	term := c.Query("term")
+	if term == "" {
+		return currentTerm, nil
+	}
+	if !validTerm(term) {
+		return "", fmt.Errorf("学期名称不合法：%s", term)
+	}
+	return term, nil
+}
+
+// termScoresOf 返回学生某学期的成绩，不存在时返回nil，调用方需持有mu
+func termScoresOf(stu *student, term string) map[string]grade {
+	if term == currentTerm {
+		return stu.Scores
+	}
+	return stu.Terms[term]
+}
+
+// setTermScores 替换学生某学期的成绩，成绩为空时删除该学期，调用方需持有mu
+func setTermScores(stu *student, term string, scores map[string]grade) {
+	if term == currentTerm {
+		stu.Scores = scores
+		return
+	}
+	if len(scores) == 0 {
+		delete(stu.Terms, term)
+		if len(stu.Terms) == 0 {
+			stu.Terms = nil
+		}
+		return
+	}
+	if stu.Terms == nil {
+		stu.Terms = make(map[string]map[string]grade)
+	}
+	stu.Terms[term] = scores
+}
+
+// transcript 按时间顺序返回学生各学期的成绩单，包括当前学期，调用方需持有mu
+func transcript(stu *student) []termScores {
+	list := make([]termScores, 0, len(stu.Terms)+1)
+	for term, scores := range stu.Terms {
+		if term != currentTerm {
+			list = append(list, termScores{Term: term, Scores: scores})
+		}
+	}
+	if len(stu.Scores) > 0 {
+		list = append(list, termScores{Term: currentTerm, Scores: stu.Scores})
+	}
+	sort.Slice(list, func(a, b int) bool {
+		return termLess(list[a].Term, list[b].Term)
+	})
+	return list
+}
+
+// normalizeTerms 检查学生各学期的学期名称和成绩，当前学期的成绩应放在Scores中
+func normalizeTerms(terms map[string]map[string]grade) (map[string]map[string]grade, error) {
+	if terms == nil {
+		return nil, nil
+	}
+	normalized := make(map[string]map[string]grade, len(terms))
+	for term, scores := range terms {
+		if !validTerm(term) {
+			return nil, fmt.Errorf("学期名称不合法：%s", term)
+		}
+		scores, err := normalizeScores(scores)
+		if err != nil {
+			return nil, err
+		}
+		computeTotals(scores)
+		normalized[term] = scores
+	}
+	return normalized, nil
+}
+
+// checkCurrentTermLocked 当前学期的成绩只保存在Scores中，Terms中不能有当前学期，否则查询和成绩单都读不到，调用方需持有mu
+func checkCurrentTermLocked(terms map[string]map[string]grade) error {
+	if _, ok := terms[currentTerm]; ok {
+		return fmt.Errorf("%s是当前学期，成绩应填写在scores中", currentTerm)
+	}
+	return nil
+}
+
+// rolloverTerm 切换当前学期：所有学生的当前成绩归入原学期，新学期已有的成绩成为当前成绩，调用方需持有mu
+// 保存当前学期失败时恢复学生的成绩，保证成绩和学期一致
+func rolloverTerm(to string) error {
+	from := currentTerm
+	var changed, original []*student
+	err := store.Range(func(stu *student) bool {
+		if len(stu.Scores) == 0 && stu.Terms[to] == nil {
+			return true
+		}
+		original = append(original, stu.clone())
+		stu = stu.clone()
+		next := stu.Terms[to]
+		delete(stu.Terms, to)
+		if len(stu.Scores) > 0 {
+			if stu.Terms == nil {
+				stu.Terms = make(map[string]map[string]grade)
+			}
+			stu.Terms[from] = stu.Scores
+		}
+		if len(stu.Terms) == 0 {
+			stu.Terms = nil
+		}
+		stu.Scores = next
+		changed = append(changed, stu)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err := store.PutAll(changed); err != nil {
+		return err
+	}
+	if err := writeTerm(to); err != nil {
+		if restoreErr := store.PutAll(original); restoreErr != nil {
+			return fmt.Errorf("保存当前学期失败：%v，恢复成绩也失败：%v", err, restoreErr)
+		}
+		return fmt.Errorf("保存当前学期失败，未切换学期：%v", err)
+	}
+	currentTerm = to
+	return nil
+}
+
+// getTerm 查询当前学期
+func getTerm(c *gin.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": termState{Current: currentTerm}})
+}
+
+// rolloverTermHandler 切换到新学期，之后不带学期参数的成绩操作都针对新学期
+func rolloverTermHandler(c *gin.Context) {
+	to := c.Query("to")
+	if !validTerm(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("学期名称不合法：%s", to)})
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if to == currentTerm {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已经是当前学期"})
+		return
+	}
+	if err := rolloverTerm(to); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": termState{Current: currentTerm}})
+}
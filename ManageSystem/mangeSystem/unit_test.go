@@ -3,19 +3,36 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/simplifiedchinese"
 	"io"
+	"math"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
+// setStudents 直接替换内存存储中的学生，并重建默认存储的索引
+func setStudents(list map[string]*student) {
+	students = list
+	idx, err := newIndexedStore(memoryStore{})
+	if err != nil {
+		panic(err)
+	}
+	store = idx
+}
+
 func TestAddStudent(t *testing.T) {
 	r := gin.Default()
 	r.POST("/student/addStudent", addStudent)
@@ -28,8 +45,8 @@ func TestAddStudent(t *testing.T) {
 		Sex:    "Male",
 		Class:  "Computer Science",
 		Number: "123456",
-		Scores: map[string]int{
-			"math": 80,
+		Scores: map[string]grade{
+			"math": numGrade(80),
 		},
 	}
 
@@ -96,15 +113,15 @@ func TestUpdateScore(t *testing.T) {
 	r.POST("/student/updateScore", addOrUpdateScore)
 
 	// 准备测试数据
-	testScores := map[string]int{"math": 95, "english": 88}
+	testScores := map[string]grade{"math": numGrade(95), "english": numGrade(88)}
 	testStudent := student{
 		Name:   "John Doe",
 		Age:    "20",
 		Sex:    "Male",
 		Class:  "Computer Science",
 		Number: "12345",
-		Scores: map[string]int{
-			"math": 80,
+		Scores: map[string]grade{
+			"math": numGrade(80),
 		},
 	}
 	students[testStudent.Number] = &testStudent
@@ -145,8 +162,8 @@ func TestDeleteStudent(t *testing.T) {
 			Sex:    "Male",
 			Class:  "Computer Science",
 			Number: studentNumber,
-			Scores: map[string]int{
-				"math": 80,
+			Scores: map[string]grade{
+				"math": numGrade(80),
 			},
 		}
 		req, _ := http.NewRequest("DELETE", "/students?number="+studentNumber, nil)
@@ -197,7 +214,7 @@ func TestDeleteScore(t *testing.T) {
 		Sex:    "Male",
 		Class:  "Computer Science",
 		Number: testStudentNumber,
-		Scores: map[string]int{"math": 80, "science": 90},
+		Scores: map[string]grade{"math": numGrade(80), "science": numGrade(90)},
 	}
 	mu.Lock()
 	students[testStudentNumber] = testStudent
@@ -215,7 +232,7 @@ func TestDeleteScore(t *testing.T) {
 		assert.JSONEq(t, `{"code":200,"msg":"操作成功","data":" "}`, w.Body.String())
 		mu.Lock()
 		defer mu.Unlock()
-		assert.True(t, students[testStudentNumber].Scores["math"] == 0)
+		assert.True(t, students[testStudentNumber].Scores["math"] == grade{})
 	})
 
 	// 测试删除不存在的成绩
@@ -262,7 +279,7 @@ func TestUpdateStudent(t *testing.T) {
 		Age:    "20",
 		Sex:    "男",
 		Class:  "一班",
-		Scores: map[string]int{"数学": 90, "英语": 80},
+		Scores: map[string]grade{"数学": numGrade(90), "英语": numGrade(80)},
 	}
 
 	// 创建一个模拟的 HTTP 请求和响应记录器
@@ -310,21 +327,21 @@ func TestUpdateStudent(t *testing.T) {
 	assert.Equal(t, "男", updatedStudent.Sex)
 	assert.Equal(t, "二班", updatedStudent.Class)
 	assert.Equal(t, 2, len(updatedStudent.Scores)) // 确保 Scores 字段被正确更新
-	assert.Equal(t, 95, updatedStudent.Scores["数学"])
-	assert.Equal(t, 100, updatedStudent.Scores["物理"])
+	assert.Equal(t, numGrade(95), updatedStudent.Scores["数学"])
+	assert.Equal(t, numGrade(100), updatedStudent.Scores["物理"])
 }
 func TestGetScore(t *testing.T) {
 	// 初始化全局变量（如果测试需要的话）
-	students = make(map[string]*student)
+	setStudents(make(map[string]*student))
 	students["12345"] = &student{
 		Name:   "张三",
 		Age:    "20",
 		Sex:    "男",
 		Class:  "一班",
 		Number: "12345",
-		Scores: map[string]int{
-			"数学": 90,
-			"英语": 85,
+		Scores: map[string]grade{
+			"数学": numGrade(90),
+			"英语": numGrade(85),
 		},
 	}
 
@@ -345,16 +362,16 @@ func TestGetScore(t *testing.T) {
 
 func TestStudent(t *testing.T) {
 	// 初始化全局变量
-	students = make(map[string]*student)
+	setStudents(make(map[string]*student))
 	students["12345"] = &student{
 		Name:   "张三",
 		Age:    "20",
 		Sex:    "男",
 		Class:  "一班",
 		Number: "12345",
-		Scores: map[string]int{
-			"数学": 90,
-			"英语": 85,
+		Scores: map[string]grade{
+			"数学": numGrade(90),
+			"英语": numGrade(85),
 		},
 	}
 
@@ -399,8 +416,8 @@ func TestStudent(t *testing.T) {
 	assert.Equal(t, "男", response.Student.Sex)
 	assert.Equal(t, "一班", response.Student.Class)
 	assert.Equal(t, "12345", response.Student.Number)
-	assert.Equal(t, 90, response.Student.Scores["数学"])
-	assert.Equal(t, 85, response.Student.Scores["英语"])
+	assert.Equal(t, numGrade(90), response.Student.Scores["数学"])
+	assert.Equal(t, numGrade(85), response.Student.Scores["英语"])
 
 	//测试错误情况
 	t.Run("NotFound", func(t *testing.T) {
@@ -436,8 +453,17 @@ func TestPostFile(t *testing.T) {
 
 	r.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusOK, rec.Code)
-	assert.JSONEq(t, `{"code":200,"msg":"上传成功","data":""}`, rec.Body.String())
-	uploadDir := "./postFile"
+	var response struct {
+		Code int        `json:"code"`
+		Msg  string     `json:"msg"`
+		Data uploadMeta `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "上传成功", response.Msg)
+	assert.Equal(t, "testfile.csv", response.Data.FileName)
+	assert.Equal(t, uploadCSV, response.Data.Type)
+	assert.NotEmpty(t, response.Data.ID)
 	files, err := os.ReadDir(uploadDir)
 	require.NoError(t, err)
 	for _, file := range files {
@@ -447,3 +473,1619 @@ func TestPostFile(t *testing.T) {
 	err = os.Remove(uploadDir)
 	require.NoError(t, err)
 }
+
+func TestBoltStore(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "students.db")
+	s, err := newStore("bolt", dbPath)
+	require.NoError(t, err)
+	store = s
+	defer setStudents(make(map[string]*student))
+
+	r := gin.Default()
+	r.POST("/student/addStudent", addStudent)
+	body := `{"name":"张三","age":"20","sex":"男","class":"一班","number":"2023001","score":{"数学":90}}`
+	req, _ := http.NewRequest("POST", "/student/addStudent", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, store.Close())
+
+	// 重新打开文件，数据应该仍然存在
+	s, err = newStore("bolt", dbPath)
+	require.NoError(t, err)
+	store = s
+	defer s.Close()
+	stu, exists, err := store.Get("2023001")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "张三", stu.Name)
+	assert.Equal(t, numGrade(90), stu.Scores["数学"])
+}
+
+func TestWALStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openWALStore(dir, 2, 0)
+	require.NoError(t, err)
+	mu.Lock()
+	for _, number := range []string{"001", "002", "003"} {
+		require.NoError(t, s.Put(&student{Name: "学生" + number, Number: number, Scores: map[string]grade{"数学": numGrade(80)}}))
+	}
+	require.NoError(t, s.Delete("002"))
+	mu.Unlock()
+	// 模拟崩溃：不生成快照直接关闭日志，并在末尾留下写了一半的记录
+	require.NoError(t, s.wal.Close())
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = wal.WriteString(`{"op":"put","student":{"num`)
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	s, err = openWALStore(dir, 2, 0)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+		setStudents(make(map[string]*student))
+	}()
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, students, 2)
+	assert.Equal(t, "学生001", students["001"].Name)
+	assert.Nil(t, students["002"])
+	assert.Equal(t, numGrade(80), students["003"].Scores["数学"])
+}
+
+func TestWALReplayCorrupt(t *testing.T) {
+	defer setStudents(make(map[string]*student))
+	write := func(dir, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, walFileName), []byte(content), 0644))
+	}
+	valid := `{"op":"put","student":{"name":"张三","number":"001"}}` + "\n"
+
+	// 末尾写完换行但内容不完整的记录视为崩溃时的残留，截断后正常启动
+	dir := t.TempDir()
+	write(dir, valid+`{"op":"put"}`+"\n")
+	s, err := openWALStore(dir, 0, 0)
+	require.NoError(t, err)
+	assert.Len(t, students, 1)
+	require.NoError(t, s.Close())
+
+	// 中间的记录损坏时拒绝启动，日志保持不变
+	for _, bad := range []string{`{"op":"put"}`, `{"op":"batch","students":[null]}`, `{"op":"x"}`, `not json`} {
+		dir := t.TempDir()
+		content := valid + bad + "\n" + valid
+		write(dir, content)
+		_, err := openWALStore(dir, 0, 0)
+		assert.Error(t, err, bad)
+		data, err := os.ReadFile(filepath.Join(dir, walFileName))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	}
+}
+
+func TestParseCSVRepeated(t *testing.T) {
+	setStudents(make(map[string]*student))
+	r := gin.Default()
+	r.POST("/csv/parseStudent", parseCSV)
+	defer os.RemoveAll(uploadDir)
+
+	// 同一进程中连续导入两次，第二次不应再因通道已关闭而panic
+	for i, number := range []string{"001", "002"} {
+		require.NoError(t, os.MkdirAll(uploadDir, 0755))
+		content := "张三,20,男,一班," + number + ",\"{\"\"数学\"\":9" + strconv.Itoa(i) + "}\"\n"
+		require.NoError(t, os.WriteFile(filepath.Join(uploadDir, "roster.csv"), []byte(content), 0644))
+		req, _ := http.NewRequest("POST", "/csv/parseStudent", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, numGrade(90), students["001"].Scores["数学"])
+	assert.Equal(t, numGrade(91), students["002"].Scores["数学"])
+}
+
+func TestParseCSVReport(t *testing.T) {
+	setStudents(make(map[string]*student))
+	r := gin.Default()
+	r.POST("/csv/parseStudent", parseCSV)
+	require.NoError(t, os.MkdirAll(uploadDir, 0755))
+	defer os.RemoveAll(uploadDir)
+	content := "张三,20,男,一班,001,\"{\"\"数学\"\":90}\"\n" +
+		"李四,20,男,一班,,\"{\"\"数学\"\":80}\"\n" +
+		"王五,20,男,一班,003,\"{\"\"数学\"\":\"\"优\"\"}\"\n" +
+		"赵六,20,男\n"
+	require.NoError(t, os.WriteFile(filepath.Join(uploadDir, "roster.csv"), []byte(content), 0644))
+
+	req, _ := http.NewRequest("POST", "/csv/parseStudent", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Data importReport `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Data.Imported)
+	assert.Equal(t, 3, response.Data.Skipped)
+	require.Len(t, response.Data.Errors, 3)
+	lines := map[int]ParseError{}
+	for _, e := range response.Data.Errors {
+		assert.Equal(t, "roster.csv", e.File)
+		lines[e.Line] = e
+	}
+	assert.Equal(t, 5, lines[2].Column)
+	assert.Equal(t, "学号不能为空", lines[2].Msg)
+	assert.Equal(t, 6, lines[3].Column)
+	assert.Equal(t, 0, lines[4].Column)
+}
+
+func TestImportJob(t *testing.T) {
+	setStudents(make(map[string]*student))
+	r := gin.Default()
+	r.POST("/csv/jobs", startImportJob)
+	r.GET("/csv/jobs/:id", getImportJob)
+	require.NoError(t, os.MkdirAll(uploadDir, 0755))
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+	content := "张三,20,男,一班,001,\"{\"\"数学\"\":90}\"\n李四,20,男,一班,,\"{}\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(uploadDir, "roster.csv"), []byte(content), 0644))
+
+	req, _ := http.NewRequest("POST", "/csv/jobs", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	var created struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+
+	var progress struct {
+		Data importProgress `json:"data"`
+	}
+	require.Eventually(t, func() bool {
+		req, _ := http.NewRequest("GET", "/csv/jobs/"+created.Data.ID, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &progress))
+		return progress.Data.Status == "finished"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, progress.Data.Read)
+	assert.Equal(t, 1, progress.Data.Imported)
+	assert.Equal(t, 1, progress.Data.Failed)
+	require.NotNil(t, progress.Data.Report)
+	require.Len(t, progress.Data.Report.Errors, 1)
+	assert.Equal(t, 2, progress.Data.Report.Errors[0].Line)
+
+	req, _ = http.NewRequest("GET", "/csv/jobs/unknown", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestPruneImportJobs(t *testing.T) {
+	defer func(retention time.Duration, limit int) {
+		jobRetention, maxFinishedJobs = retention, limit
+		importJobs = make(map[string]*asyncImport)
+	}(jobRetention, maxFinishedJobs)
+	jobRetention, maxFinishedJobs = time.Hour, 2
+	now := time.Now()
+	importJobs = make(map[string]*asyncImport)
+	add := func(id string, done bool, finishedAt time.Time) {
+		importJobs[id] = &asyncImport{job: &importJob{ID: id}, done: done, finishedAt: finishedAt}
+	}
+	add("running", false, time.Time{})
+	add("expired", true, now.Add(-2*time.Hour))
+	add("old", true, now.Add(-30*time.Minute))
+	add("middle", true, now.Add(-20*time.Minute))
+	add("recent", true, now.Add(-10*time.Minute))
+	jobsMu.Lock()
+	pruneImportJobs(now)
+	jobsMu.Unlock()
+	var ids []string
+	for id := range importJobs {
+		ids = append(ids, id)
+	}
+	assert.ElementsMatch(t, []string{"running", "middle", "recent"}, ids)
+
+	// 同一行的多个错误只计为一个出错行
+	job := newImportJob(importOptions{Layout: layoutJSON, Mode: modeOverwrite})
+	report := job.runFeed(func() {
+		job.errorChan <- ParseError{File: "a.csv", Line: 2, Msg: "错误一"}
+		job.errorChan <- ParseError{File: "a.csv", Line: 2, Msg: "错误二"}
+		job.errorChan <- ParseError{File: "a.csv", Line: 3, Msg: "错误三"}
+	})
+	assert.Equal(t, int64(2), job.failed.Load())
+	assert.Equal(t, 2, report.Skipped)
+}
+
+func TestParseCSVHeader(t *testing.T) {
+	setStudents(make(map[string]*student))
+	r := gin.Default()
+	r.POST("/csv/parseStudent", parseCSV)
+	require.NoError(t, os.MkdirAll(uploadDir, 0755))
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+	// 列顺序不同、带有多余的备注列，并使用中文表头
+	content := "\ufeff学号,班级,备注,姓名,性别,年龄,成绩\n" +
+		"001,一班,转学生,张三,男,20,\"{\"\"数学\"\":90}\"\n" +
+		"002,二班,,李四,女,19,\n"
+	require.NoError(t, os.WriteFile(filepath.Join(uploadDir, "roster.csv"), []byte(content), 0644))
+
+	req, _ := http.NewRequest("POST", "/csv/parseStudent", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Data importReport `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Data.Imported)
+	assert.Empty(t, response.Data.Errors)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, student{Name: "张三", Age: "20", Sex: "男", Class: "一班", Number: "001", Scores: map[string]grade{"数学": numGrade(90)}}, *students["001"])
+	assert.Equal(t, "二班", students["002"].Class)
+	assert.Nil(t, students["002"].Scores)
+}
+
+func TestDetectLayout(t *testing.T) {
+	_, isHeader, err := detectLayout([]string{"姓名", "班级"}, layoutJSON)
+	assert.True(t, isHeader)
+	assert.EqualError(t, err, "表头缺少学号列")
+
+	_, _, err = detectLayout([]string{"学号", "number"}, layoutJSON)
+	assert.EqualError(t, err, "第2列表头中number列重复")
+
+	layout, isHeader, err := detectLayout([]string{"张三", "20", "男", "一班", "001", "{}"}, layoutJSON)
+	require.NoError(t, err)
+	assert.False(t, isHeader)
+	assert.Same(t, positionalLayout, layout)
+}
+
+func TestParseCSVWide(t *testing.T) {
+	setStudents(make(map[string]*student))
+	r := gin.Default()
+	r.POST("/csv/parseStudent", parseCSV)
+	require.NoError(t, os.MkdirAll(uploadDir, 0755))
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+	content := "姓名,学号,班级,数学,语文,英语\n" +
+		"张三,001,一班,90,85,\n" +
+		"李四,002,一班,88,缺考,70\n" +
+		"王五,003,一班,88.5,九十,A-\n" +
+		"赵六,004,一班,甲,乙,丙\n"
+	require.NoError(t, os.WriteFile(filepath.Join(uploadDir, "roster.csv"), []byte(content), 0644))
+
+	req, _ := http.NewRequest("POST", "/csv/parseStudent?layout=wide", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Data importReport `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Data.Imported)
+	require.Len(t, response.Data.Errors, 2)
+	assert.Equal(t, ParseError{File: "roster.csv", Line: 4, Column: 5, Msg: "语文成绩无法识别：九十"}, response.Data.Errors[0])
+	// 多个单元格出错时总是报告第一个出错的列
+	assert.Equal(t, ParseError{File: "roster.csv", Line: 5, Column: 4, Msg: "数学成绩无法识别：甲"}, response.Data.Errors[1])
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]grade{"数学": numGrade(90), "语文": numGrade(85)}, students["001"].Scores)
+	assert.Equal(t, map[string]grade{"数学": numGrade(88), "语文": {Mark: markAbsent}, "英语": numGrade(70)}, students["002"].Scores)
+
+	req, _ = http.NewRequest("POST", "/csv/parseStudent?layout=tall", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+// importCSV 把content写入上传目录并调用导入接口，返回导入报告
+func importCSV(t *testing.T, query, content string) importReport {
+	r := gin.Default()
+	r.POST("/csv/parseStudent", parseCSV)
+	require.NoError(t, os.MkdirAll(uploadDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(uploadDir, "roster.csv"), []byte(content), 0644))
+	req, _ := http.NewRequest("POST", "/csv/parseStudent"+query, nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var response struct {
+		Data importReport `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	return response.Data
+}
+
+func TestParseCSVConflictMode(t *testing.T) {
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+	content := "学号,姓名,班级,成绩\n" +
+		"001,张三,二班,\"{\"\"语文\"\":70}\"\n" +
+		"002,李四,二班,\n"
+	reset := func() {
+		setStudents(map[string]*student{
+			"001": {Name: "张三", Age: "20", Class: "一班", Number: "001", Scores: map[string]grade{"数学": numGrade(90)}},
+		})
+	}
+
+	t.Run("insert", func(t *testing.T) {
+		reset()
+		report := importCSV(t, "?mode=insert", content)
+		assert.Equal(t, 1, report.Imported)
+		assert.Equal(t, 1, report.Inserted)
+		assert.Equal(t, 1, report.Kept)
+		assert.Equal(t, []importConflict{{File: "roster.csv", Line: 2, Number: "001", Action: actionKept}}, report.Conflicts)
+		assert.Equal(t, "一班", students["001"].Class)
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		reset()
+		report := importCSV(t, "", content)
+		assert.Equal(t, 2, report.Imported)
+		assert.Equal(t, 1, report.Overwritten)
+		assert.Equal(t, "", students["001"].Age)
+		assert.Equal(t, map[string]grade{"语文": numGrade(70)}, students["001"].Scores)
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		reset()
+		report := importCSV(t, "?mode=merge", content)
+		assert.Equal(t, 2, report.Imported)
+		assert.Equal(t, 1, report.Merged)
+		assert.Equal(t, []importConflict{{File: "roster.csv", Line: 2, Number: "001", Action: actionMerged}}, report.Conflicts)
+		assert.Equal(t, "20", students["001"].Age)
+		assert.Equal(t, "二班", students["001"].Class)
+		assert.Equal(t, map[string]grade{"数学": numGrade(90), "语文": numGrade(70)}, students["001"].Scores)
+	})
+
+	t.Run("repeated number", func(t *testing.T) {
+		// 同一学号出现多次时按行号顺序写入，最后一行的结果生效
+		reset()
+		repeated := "学号,姓名,年龄\n"
+		for i := 1; i <= 50; i++ {
+			repeated += fmt.Sprintf("003,王五,%d\n", i)
+		}
+		report := importCSV(t, "", repeated)
+		assert.Equal(t, 50, report.Imported)
+		assert.Equal(t, "50", students["003"].Age)
+	})
+}
+
+func TestParseCSVDryRun(t *testing.T) {
+	setStudents(make(map[string]*student))
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+	content := "学号,姓名,成绩\n" +
+		"001,张三,\"{\"\"数学\"\":90}\"\n" +
+		",李四,\n"
+	report := importCSV(t, "?dryRun=true", content)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 0, report.Imported)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, []parsedRow{{File: "roster.csv", Line: 2, Student: student{Name: "张三", Number: "001", Scores: map[string]grade{"数学": numGrade(90)}}}}, report.Rows)
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, 3, report.Errors[0].Line)
+	assert.Empty(t, students)
+	// 校验后文件仍在上传目录，可以直接正式导入
+	_, err := os.Stat(filepath.Join(uploadDir, "roster.csv"))
+	assert.NoError(t, err)
+}
+
+func TestParseCSVAtomic(t *testing.T) {
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+	r := gin.Default()
+	r.POST("/csv/parseStudent", parseCSV)
+	post := func(content string) (int, importReport) {
+		require.NoError(t, os.MkdirAll(uploadDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(uploadDir, "roster.csv"), []byte(content), 0644))
+		req, _ := http.NewRequest("POST", "/csv/parseStudent?atomic=true&mode=merge", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var response struct {
+			Data importReport `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return rr.Code, response.Data
+	}
+	setStudents(map[string]*student{"001": {Name: "张三", Number: "001", Scores: map[string]grade{"数学": numGrade(90)}}})
+
+	// 有一行出错，整个文件都不导入
+	code, report := post("学号,姓名,成绩\n001,张三,\"{\"\"语文\"\":80}\"\n002,李四,\n,王五,\n")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.True(t, report.RolledBack)
+	assert.Equal(t, 0, report.Imported)
+	assert.Len(t, report.Errors, 1)
+	assert.Len(t, students, 1)
+	assert.Equal(t, map[string]grade{"数学": numGrade(90)}, students["001"].Scores)
+	// 回滚后文件及其元数据放回上传目录，修改后可以再次导入
+	require.NoError(t, os.Remove(filepath.Join(uploadDir, "roster.csv")))
+	meta, err := saveUpload("名单.csv", strings.NewReader("学号,姓名\n003,王五\n,赵六\n"))
+	require.NoError(t, err)
+	code, _ = post("学号,姓名\n,赵六\n")
+	assert.Equal(t, http.StatusBadRequest, code)
+	uploads, err := listUploads(nil)
+	require.NoError(t, err)
+	require.Len(t, uploads, 2)
+	assert.Contains(t, []string{uploads[0].FileName, uploads[1].FileName}, "名单.csv")
+	assert.Contains(t, []string{uploads[0].ID, uploads[1].ID}, meta.ID)
+	require.NoError(t, os.Remove(filepath.Join(uploadDir, meta.ID)))
+	require.NoError(t, os.Remove(filepath.Join(uploadDir, meta.ID+".json")))
+
+	// 全部正确时一次性写入，同一学号多次出现时按行号顺序合并
+	code, report = post("学号,姓名,成绩\n001,张三,\"{\"\"语文\"\":80}\"\n002,李四,\n001,,\"{\"\"英语\"\":70}\"\n")
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, report.RolledBack)
+	assert.Equal(t, 3, report.Imported)
+	assert.Equal(t, 2, report.Merged)
+	assert.Equal(t, map[string]grade{"数学": numGrade(90), "语文": numGrade(80), "英语": numGrade(70)}, students["001"].Scores)
+	assert.Equal(t, "李四", students["002"].Name)
+	_, err = os.Stat(filepath.Join(uploadDir, "roster.csv"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestImportStream(t *testing.T) {
+	setStudents(make(map[string]*student))
+	r := gin.Default()
+	r.POST("/csv/import", importStream)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, content := range map[string]string{
+		"一班.csv": "学号,姓名,班级\n001,张三,一班\n",
+		"二班.csv": "学号,姓名,班级\n002,李四,二班\n,王五,二班\n",
+	} {
+		file, err := writer.CreateFormFile("file", name)
+		require.NoError(t, err)
+		_, err = file.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	req, _ := http.NewRequest("POST", "/csv/import", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Data importReport `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Data.Imported)
+	require.Len(t, response.Data.Errors, 1)
+	assert.Equal(t, ParseError{File: "二班.csv", Line: 3, Column: 1, Msg: "学号不能为空"}, response.Data.Errors[0])
+	assert.Equal(t, "张三", students["001"].Name)
+	assert.Equal(t, "二班", students["002"].Class)
+	// 不经过上传目录
+	_, err := os.Stat(uploadDir)
+	assert.True(t, os.IsNotExist(err))
+
+	req, _ = http.NewRequest("POST", "/csv/import", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// CSV和XLSX超过大小限制时都报告文件过大
+	defer func(size int64) { maxUploadSize = size }(maxUploadSize)
+	maxUploadSize = 64
+	body = &bytes.Buffer{}
+	writer = multipart.NewWriter(body)
+	for name, content := range map[string]string{
+		"big.csv":  "学号,姓名,班级\n" + strings.Repeat("003,王五,三班\n", 10),
+		"big.xlsx": "PK\x03\x04" + strings.Repeat("x", 100),
+		"ok.csv":   "学号,姓名\n004,赵六\n",
+	} {
+		file, err := writer.CreateFormFile("file", name)
+		require.NoError(t, err)
+		_, err = file.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	req, _ = http.NewRequest("POST", "/csv/import", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	files := make(map[string]string)
+	for _, e := range response.Data.Errors {
+		files[e.File] = e.Msg
+	}
+	assert.Contains(t, files["big.csv"], errUploadTooLarge.Error())
+	assert.Contains(t, files["big.xlsx"], errUploadTooLarge.Error())
+	// 超过限制的文件在报错之前读到的行也不写入，其他文件照常导入
+	assert.Equal(t, 1, response.Data.Imported)
+	assert.NotContains(t, students, "003")
+	assert.Equal(t, "赵六", students["004"].Name)
+}
+
+func TestUploadStore(t *testing.T) {
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+	setStudents(make(map[string]*student))
+	r := gin.Default()
+	r.POST("/csv/postFile", postFile)
+	r.GET("/csv/uploads", getUploads)
+	r.DELETE("/csv/uploads/:id", deleteUpload)
+	r.POST("/csv/parseStudent", parseCSV)
+	upload := func(name string, content []byte) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		file, err := writer.CreateFormFile("file", name)
+		require.NoError(t, err)
+		_, err = file.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		req, _ := http.NewRequest("POST", "/csv/postFile", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	var first, second struct {
+		Data uploadMeta `json:"data"`
+	}
+
+	// 客户端提供的文件名不会被用作路径，同名文件也不会互相覆盖
+	rr := upload("../../一班.csv", []byte("学号,姓名\n001,张三\n"))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &first))
+	rr = upload("../../一班.csv", []byte("学号,姓名\n002,李四\n"))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &second))
+	assert.NotEqual(t, first.Data.ID, second.Data.ID)
+	assert.Equal(t, "一班.csv", first.Data.FileName)
+	_, err := os.Stat(filepath.Join(uploadDir, first.Data.ID))
+	assert.NoError(t, err)
+
+	rr = upload("photo.csv", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+
+	defer func(size int64) { maxUploadSize = size }(maxUploadSize)
+	maxUploadSize = 16
+	rr = upload("big.csv", []byte(strings.Repeat("a,b\n", 10)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	req, _ := http.NewRequest("GET", "/csv/uploads", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	var list struct {
+		Data []uploadMeta `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	require.Len(t, list.Data, 2)
+
+	// 只导入指定的上传文件
+	req, _ = http.NewRequest("POST", "/csv/parseStudent?upload="+second.Data.ID, nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Nil(t, students["001"])
+	assert.Equal(t, "李四", students["002"].Name)
+
+	req, _ = http.NewRequest("DELETE", "/csv/uploads/"+first.Data.ID, nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	entries, err := os.ReadDir(uploadDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// 直接放入上传目录的文件以文件名作为ID列出，也可以用该ID删除
+	require.NoError(t, os.WriteFile(filepath.Join(uploadDir, "roster.csv"), []byte("学号,姓名\n003,王五\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(uploadDir, first.Data.ID+".json"), []byte("{}"), 0644))
+	uploads, err := listUploads(nil)
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+	assert.Equal(t, "roster.csv", uploads[0].ID)
+	req, _ = http.NewRequest("DELETE", "/csv/uploads/roster.csv", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	for _, id := range []string{"..", first.Data.ID + ".json", first.Data.ID + ".tmp"} {
+		req, _ = http.NewRequest("DELETE", "/csv/uploads/"+id, nil)
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, id)
+	}
+	_, err = os.Stat(filepath.Join(uploadDir, first.Data.ID+".json"))
+	assert.NoError(t, err)
+}
+
+func TestParseXLSX(t *testing.T) {
+	setStudents(make(map[string]*student))
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+	f := excelize.NewFile()
+	require.NoError(t, f.SetSheetName("Sheet1", "一班"))
+	require.NoError(t, f.SetSheetRow("一班", "A1", &[]interface{}{"姓名", "学号", "班级", "数学", "语文"}))
+	require.NoError(t, f.SetSheetRow("一班", "A2", &[]interface{}{"张三", 2023212069, "一班", 90, 85}))
+	// 中间的空行不影响行号
+	require.NoError(t, f.SetSheetRow("一班", "A4", &[]interface{}{"李四", "2023212070", "一班", 88}))
+	_, err := f.NewSheet("二班")
+	require.NoError(t, err)
+	require.NoError(t, f.SetSheetRow("二班", "A1", &[]interface{}{"姓名", "学号", "班级", "数学"}))
+	require.NoError(t, f.SetSheetRow("二班", "A2", &[]interface{}{"王五", "2023212071", "二班", "优秀"}))
+	require.NoError(t, os.MkdirAll(uploadDir, 0755))
+	require.NoError(t, f.SaveAs(filepath.Join(uploadDir, "成绩.xlsx")))
+
+	report := importCSV(t, "?layout=wide&upload=成绩.xlsx", "")
+	assert.Equal(t, 2, report.Imported)
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, ParseError{File: "成绩.xlsx", Sheet: "二班", Line: 2, Column: 4, Msg: "数学成绩无法识别：优秀"}, report.Errors[0])
+	assert.Equal(t, map[string]grade{"数学": numGrade(90), "语文": numGrade(85)}, students["2023212069"].Scores)
+	assert.Equal(t, "李四", students["2023212070"].Name)
+}
+
+func TestParseCSVGBK(t *testing.T) {
+	setStudents(make(map[string]*student))
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+	gbk, err := simplifiedchinese.GBK.NewEncoder().String("学号,姓名,班级\n001,张三,一班\n")
+	require.NoError(t, err)
+	report := importCSV(t, "", gbk)
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, "张三", students["001"].Name)
+	assert.Equal(t, "一班", students["001"].Class)
+
+	// 开头一大段都是ASCII时也能根据整个文件识别出编码
+	setStudents(make(map[string]*student))
+	padding := "number,name\n" + strings.Repeat("000,x\n", 2000)
+	tail, err := simplifiedchinese.GBK.NewEncoder().String("002,李四\n")
+	require.NoError(t, err)
+	report = importCSV(t, "", padding+tail)
+	assert.Equal(t, 2001, report.Imported)
+	assert.Equal(t, "李四", students["002"].Name)
+
+	// 声明的编码优先于自动识别
+	setStudents(make(map[string]*student))
+	report = importCSV(t, "?encoding=GB18030", gbk)
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, "张三", students["001"].Name)
+
+	assert.Equal(t, encodingUTF8BOM, detectEncoding([]byte("\ufeff学号")))
+	assert.Equal(t, encodingUTF8, detectEncoding([]byte("学号")[:4]))
+	assert.Equal(t, encodingGB18030, detectEncoding([]byte(gbk)))
+}
+
+func TestExportStudents(t *testing.T) {
+	original := map[string]*student{
+		"001": {Name: "张三", Age: "20", Sex: "男", Class: "一班", Number: "001", Scores: map[string]grade{"数学": numGrade(90), "语文": numGrade(85)}},
+		"002": {Name: "李四", Age: "19", Sex: "女", Class: "一班", Number: "002", Scores: map[string]grade{"英语": numGrade(70)}},
+		"003": {Name: "王五", Age: "21", Sex: "男", Class: "二班", Number: "003"},
+	}
+	setStudents(make(map[string]*student))
+	for _, stu := range original {
+		require.NoError(t, store.Put(stu.clone()))
+	}
+	r := gin.Default()
+	r.GET("/csv/export", exportStudents)
+	r.POST("/csv/import", importStream)
+	export := func(query string) []byte {
+		req, _ := http.NewRequest("GET", "/csv/export"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		return rr.Body.Bytes()
+	}
+
+	// 导出的文件可以原样导入
+	for _, layout := range []string{layoutJSON, layoutWide} {
+		data := export("?layout=" + layout)
+		setStudents(make(map[string]*student))
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		file, err := writer.CreateFormFile("file", "students.csv")
+		require.NoError(t, err)
+		_, err = file.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		req, _ := http.NewRequest("POST", "/csv/import?layout="+layout, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, original["001"], students["001"], layout)
+		assert.Equal(t, original["002"], students["002"], layout)
+		assert.Equal(t, "王五", students["003"].Name, layout)
+	}
+
+	// 按班级和课程筛选，导出为XLSX宽表
+	data := export("?format=xlsx&layout=wide&class=一班&subject=数学")
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	defer f.Close()
+	rows, err := f.GetRows("Sheet1")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"name", "age", "sex", "class", "number", "数学"},
+		{"张三", "20", "男", "一班", "001", "90"},
+	}, rows)
+
+	// 注册课程后可以使用代码或别名筛选
+	courses = map[string]*course{"MATH": {Code: "MATH", Name: "数学", MaxScore: 100, PassLine: 60, Aliases: []string{"math"}}}
+	require.NoError(t, rebuildCourseLookup())
+	defer func() {
+		courses = make(map[string]*course)
+		courseLookup = make(map[string]string)
+	}()
+	data = export("?layout=wide&subject=math")
+	assert.Equal(t, "\ufeffname,age,sex,class,number,数学\n张三,20,男,一班,001,90\n", string(data))
+
+	// 写出响应时不持有锁，下载缓慢不会阻塞其他请求
+	locked := &lockCheckWriter{ResponseRecorder: httptest.NewRecorder()}
+	req, _ := http.NewRequest("GET", "/csv/export", nil)
+	r.ServeHTTP(locked, req)
+	assert.Equal(t, http.StatusOK, locked.Code)
+	assert.False(t, locked.sawLock)
+
+	req, _ = http.NewRequest("GET", "/csv/export?format=pdf", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+// lockCheckWriter 每次写入响应时检查mu是否被持有
+type lockCheckWriter struct {
+	*httptest.ResponseRecorder
+	sawLock bool
+}
+
+func (w *lockCheckWriter) Write(data []byte) (int, error) {
+	if mu.TryLock() {
+		mu.Unlock()
+	} else {
+		w.sawLock = true
+	}
+	return w.ResponseRecorder.Write(data)
+}
+
+func TestListStudents(t *testing.T) {
+	setStudents(map[string]*student{
+		"001": {Name: "张三", Age: "20", Sex: "男", Class: "一班", Number: "001", Scores: map[string]grade{"数学": numGrade(90), "语文": numGrade(85)}},
+		"002": {Name: "李四", Age: "19", Sex: "女", Class: "一班", Number: "002", Scores: map[string]grade{"数学": numGrade(70)}},
+		"003": {Name: "王五", Age: "21", Sex: "男", Class: "二班", Number: "003", Scores: map[string]grade{"语文": numGrade(60)}},
+		"004": {Name: "张小明", Age: "18", Sex: "男", Class: "二班", Number: "004"},
+	})
+	r := gin.Default()
+	r.GET("/student/list", listStudents)
+	list := func(query string) listPage {
+		req, _ := http.NewRequest("GET", "/student/list"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp struct {
+			Data listPage `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp.Data
+	}
+	numbers := func(page listPage) []string {
+		var list []string
+		for _, stu := range page.Items {
+			list = append(list, stu.Number)
+		}
+		return list
+	}
+
+	assert.Equal(t, []string{"001", "002"}, numbers(list("?class=一班")))
+	assert.Equal(t, []string{"001", "003"}, numbers(list("?sex=男&minAge=19&maxAge=21")))
+	assert.Equal(t, []string{"001", "004"}, numbers(list("?name=张")))
+	assert.Equal(t, []string{"001", "004"}, numbers(list("?name=Z")))
+	assert.Equal(t, []string{"001"}, numbers(list("?name=zs")))
+	assert.Equal(t, []string{"001"}, numbers(list("?subject=数学&subject=语文")))
+	assert.Equal(t, []string{"001"}, numbers(list("?scoreSubject=数学&minScore=80")))
+	assert.Equal(t, []string{"002", "003"}, numbers(list("?minScore=60&maxScore=70")))
+
+	// 按成绩降序排序，没有该课程成绩的排在最后
+	assert.Equal(t, []string{"001", "002", "003", "004"}, numbers(list("?sort=score:数学&order=desc")))
+	assert.Equal(t, []string{"004", "002", "001", "003"}, numbers(list("?sort=age")))
+
+	// 注册课程后可以使用代码或别名
+	courses = map[string]*course{
+		"MATH": {Code: "MATH", Name: "数学", MaxScore: 100, PassLine: 60, Aliases: []string{"math"}},
+		"CHN":  {Code: "CHN", Name: "语文", MaxScore: 100, PassLine: 60},
+	}
+	require.NoError(t, rebuildCourseLookup())
+	defer func() {
+		courses = make(map[string]*course)
+		courseLookup = make(map[string]string)
+	}()
+	assert.Equal(t, []string{"001"}, numbers(list("?subject=Math&subject=CHN")))
+	assert.Equal(t, []string{"001"}, numbers(list("?scoreSubject=math&minScore=80")))
+	assert.Equal(t, []string{"001", "002", "003", "004"}, numbers(list("?sort=score:MATH&order=desc")))
+	req, _ := http.NewRequest("GET", "/student/list?subject=物理", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// 游标翻页，翻页期间删除已返回的学生不影响后续页
+	page := list("?sort=total&order=desc&limit=3")
+	assert.Equal(t, 4, page.Total)
+	assert.Equal(t, []string{"001", "002", "003"}, numbers(page))
+	require.NotEmpty(t, page.NextCursor)
+	require.NoError(t, store.Delete("001"))
+	page = list("?sort=total&order=desc&limit=3&cursor=" + page.NextCursor)
+	assert.Equal(t, []string{"004"}, numbers(page))
+	assert.Empty(t, page.NextCursor)
+
+	for _, query := range []string{"?sort=height", "?order=up", "?cursor=bad!", "?minAge=x"} {
+		req, _ := http.NewRequest("GET", "/student/list"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+}
+
+func TestStudentIndex(t *testing.T) {
+	setStudents(map[string]*student{
+		"001": {Name: "张三", Class: "一班", Number: "001", Scores: map[string]grade{"数学": numGrade(90)}},
+	})
+	s, err := newStore("memory", "")
+	require.NoError(t, err)
+	store = s
+	defer setStudents(make(map[string]*student))
+	index := s.(studentIndex)
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+
+	r := gin.Default()
+	r.POST("/student/addStudent", addStudent)
+	r.POST("/student/addScore", addOrUpdateScore)
+	r.PUT("/student/updateStudent", updateStudent)
+	r.DELETE("/student/deleteStudent", deleteStudent)
+	r.DELETE("/student/deleteScore", deleteScore)
+	r.GET("/student/list", listStudents)
+	do := func(method, url, body string) {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// 启动时已有的学生被索引
+	assert.Equal(t, []string{"001"}, index.ByClass("一班"))
+	do("POST", "/student/addStudent", `{"name":"李四","class":"一班","number":"002"}`)
+	do("POST", "/student/addScore?number=002", `{"数学":70,"语文":80}`)
+	assert.Equal(t, []string{"001", "002"}, index.ByClass("一班"))
+	assert.Equal(t, []string{"001", "002"}, index.BySubject("数学"))
+
+	// 修改班级和删除成绩后旧的索引项被移除
+	do("PUT", "/student/updateStudent?number=001", `{"class":"二班"}`)
+	do("DELETE", "/student/deleteScore?number=002", `["数学"]`)
+	assert.Equal(t, []string{"002"}, index.ByClass("一班"))
+	assert.Equal(t, []string{"001"}, index.ByClass("二班"))
+	assert.Equal(t, []string{"001"}, index.BySubject("数学"))
+	assert.Equal(t, []string{"002"}, index.BySubject("语文"))
+
+	// 导入任务写入的学生同样被索引
+	importCSV(t, "", "王五,20,男,二班,003,\"{\"\"数学\"\":60}\"\n")
+	assert.Equal(t, []string{"001", "003"}, index.ByClass("二班"))
+	assert.Equal(t, []string{"001", "003"}, index.BySubject("数学"))
+
+	do("DELETE", "/student/deleteStudent?number=001", "")
+	assert.Equal(t, []string{"003"}, index.ByClass("二班"))
+	assert.Equal(t, []string{"003"}, index.BySubject("数学"))
+	assert.Empty(t, index.ByClass("三班"))
+
+	// 列表查询通过索引取出班级和课程的学生
+	req, _ := http.NewRequest("GET", "/student/list?class=二班&subject=数学", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp struct {
+		Data listPage `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp.Data.Items, 1)
+	assert.Equal(t, "王五", resp.Data.Items[0].Name)
+}
+
+func TestCourseRegistry(t *testing.T) {
+	setStudents(map[string]*student{
+		"001": {Name: "张三", Class: "一班", Number: "001"},
+	})
+	path := filepath.Join(t.TempDir(), "courses.json")
+	require.NoError(t, loadCourses(path))
+	defer func() {
+		courses = make(map[string]*course)
+		courseLookup = make(map[string]string)
+		courseFile = ""
+	}()
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+
+	r := gin.Default()
+	r.POST("/course/addCourse", addCourse)
+	r.PUT("/course/updateCourse", updateCourse)
+	r.DELETE("/course/deleteCourse", deleteCourse)
+	r.GET("/course/getCourse", getCourse)
+	r.POST("/student/addScore", addOrUpdateScore)
+	r.GET("/student/getScore", getScore)
+	r.DELETE("/student/deleteScore", deleteScore)
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("POST", "/course/addCourse", `{"code":"MATH101","name":"数学","credits":4,"maxScore":150,"aliases":["math"]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"passLine":90`)
+	rr = do("POST", "/course/addCourse", `{"code":"CHN101","name":"语文","credits":3}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	// 名称或别名与已有课程冲突
+	assert.Equal(t, http.StatusConflict, do("POST", "/course/addCourse", `{"code":"M2","name":"Math"}`).Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/course/getCourse?code=MATH", "").Code)
+
+	// 别名和大小写、空白不同的名称都记到课程名称下
+	assert.Equal(t, http.StatusOK, do("POST", "/student/addScore?number=001", `{"Math ":140,"CHN101":80}`).Code)
+	assert.Equal(t, map[string]grade{"数学": numGrade(140), "语文": numGrade(80)}, students["001"].Scores)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addScore?number=001", `{"物理":90}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addScore?number=001", `{"语文":101}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addScore?number=001", `{"数学":90,"math":91}`).Code)
+	rr = do("GET", "/student/getScore?number=001&lessonName=math", "")
+	assert.JSONEq(t, `{"code":200,"msg":"操作成功","data":140}`, rr.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/student/getScore?number=001&lessonName=物理", "").Code)
+
+	// 导入时同样校验课程
+	report := importCSV(t, "", "李四,20,男,一班,002,\"{\"\"MATH\"\":100}\"\n王五,20,男,一班,003,\"{\"\"物理\"\":100}\"\n")
+	assert.Equal(t, 1, report.Imported)
+	require.Len(t, report.Errors, 1)
+	assert.Contains(t, report.Errors[0].Msg, "未知的课程")
+	assert.Equal(t, numGrade(100), students["002"].Scores["数学"])
+	report = importCSV(t, "?layout=wide", "学号,姓名,语文\n004,赵六,120\n")
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, 3, report.Errors[0].Column)
+
+	// 有成绩的课程不能删除，改名后成绩迁移到新名称
+	assert.Equal(t, http.StatusConflict, do("DELETE", "/course/deleteCourse?code=CHN101", "").Code)
+	rr = do("PUT", "/course/updateCourse?code=CHN101", `{"name":"国文","credits":3}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, map[string]grade{"数学": numGrade(140), "国文": numGrade(80)}, students["001"].Scores)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/student/deleteScore?number=001", `["国文"]`).Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/course/deleteCourse?code=CHN101", "").Code)
+
+	// 注册之前录入的成绩统一为课程名称；改名或注册时与未注册的成绩冲突则拒绝
+	mu.Lock()
+	require.NoError(t, store.Put(&student{Name: "孙七", Number: "005", Scores: map[string]grade{"ENG": numGrade(70), "Physics": numGrade(60)},
+		Terms: map[string]map[string]grade{"2025-Fall": {"english": numGrade(75)}}}))
+	require.NoError(t, store.Put(&student{Name: "周八", Number: "006", Scores: map[string]grade{"物理": numGrade(80), "physics": numGrade(81)}}))
+	mu.Unlock()
+	rr = do("POST", "/course/addCourse", `{"code":"ENG101","name":"英语","credits":2,"aliases":["eng","english"]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, map[string]grade{"英语": numGrade(70), "Physics": numGrade(60)}, students["005"].Scores)
+	assert.Equal(t, map[string]grade{"英语": numGrade(75)}, students["005"].Terms["2025-Fall"])
+	assert.Equal(t, http.StatusConflict, do("PUT", "/course/updateCourse?code=ENG101", `{"name":"Physics","credits":2}`).Code)
+	assert.Equal(t, numGrade(70), students["005"].Scores["英语"])
+	assert.Equal(t, http.StatusConflict, do("POST", "/course/addCourse", `{"code":"PHY101","name":"物理","aliases":["physics"]}`).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/course/getCourse?code=PHY101", "").Code)
+	assert.Equal(t, numGrade(80), students["006"].Scores["物理"])
+	mu.Lock()
+	require.NoError(t, store.Delete("005"))
+	require.NoError(t, store.Delete("006"))
+	mu.Unlock()
+	assert.Equal(t, http.StatusOK, do("DELETE", "/course/deleteCourse?code=ENG101", "").Code)
+
+	// 课程写入文件，重新读取后仍然存在
+	require.NoError(t, loadCourses(path))
+	assert.Len(t, courses, 1)
+	name, c, err := canonicalSubject("math")
+	require.NoError(t, err)
+	assert.Equal(t, "数学", name)
+	assert.Equal(t, 4.0, c.Credits)
+}
+
+func TestClassRegistry(t *testing.T) {
+	setStudents(map[string]*student{
+		"001": {Name: "张三", Class: "一班", Number: "001"},
+		"002": {Name: "李四", Class: "一班", Number: "002"},
+	})
+	s, err := newStore("memory", "")
+	require.NoError(t, err)
+	store = s
+	defer setStudents(make(map[string]*student))
+	path := filepath.Join(t.TempDir(), "classes.json")
+	require.NoError(t, loadClasses(path))
+	defer func() {
+		classes = make(map[string]*classInfo)
+		classFile = ""
+	}()
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+
+	r := gin.Default()
+	r.POST("/class/addClass", addClass)
+	r.PUT("/class/updateClass", updateClass)
+	r.GET("/class/listClasses", listClasses)
+	r.GET("/class/roster", getRoster)
+	r.POST("/class/moveStudents", moveStudents)
+	r.POST("/student/addStudent", addStudent)
+	r.PUT("/student/updateStudent", updateStudent)
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusOK, do("POST", "/class/addClass", `{"name":"一班","headTeacher":"王老师"}`).Code)
+	require.Equal(t, http.StatusOK, do("POST", "/class/addClass", `{"name":"二班"}`).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", "/class/addClass", `{"name":"二班"}`).Code)
+
+	// 班级存在后，学生只能加入或转入已有的班级
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/student/updateStudent?number=001", `{"class":"三班"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addStudent", `{"name":"王五","class":"三班","number":"003"}`).Code)
+	assert.Equal(t, http.StatusOK, do("PUT", "/student/updateStudent?number=001", `{"class":"二班"}`).Code)
+	report := importCSV(t, "", "赵六,20,男,三班,004,\n")
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, 4, report.Errors[0].Column)
+
+	// 转班是原子的，任一学生不存在时都不转
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/class/moveStudents?to=二班", `["002","009"]`).Code)
+	assert.Equal(t, "一班", students["002"].Class)
+	assert.Equal(t, http.StatusOK, do("POST", "/class/moveStudents?to=二班", `["002"]`).Code)
+	assert.Equal(t, http.StatusNotFound, do("POST", "/class/moveStudents?to=三班", `["002"]`).Code)
+	// 存储写入失败时返回500
+	saved := store
+	store = failingStore{store}
+	assert.Equal(t, http.StatusInternalServerError, do("POST", "/class/moveStudents?to=一班", `["002"]`).Code)
+	store = saved
+
+	// 改名时学生的班级一并修改
+	rr := do("PUT", "/class/updateClass?name=二班", `{"name":"高一二班","headTeacher":"李老师"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "高一二班", students["001"].Class)
+	assert.Equal(t, "高一二班", students["002"].Class)
+	rr = do("GET", "/class/roster?name=高一二班", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var roster struct {
+		Data struct {
+			Class    classInfo  `json:"class"`
+			Students []*student `json:"students"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &roster))
+	assert.Equal(t, "李老师", roster.Data.Class.HeadTeacher)
+	require.Len(t, roster.Data.Students, 2)
+	assert.Equal(t, "001", roster.Data.Students[0].Number)
+
+	// 归档的班级不能再转入学生，默认不在列表中
+	assert.Equal(t, http.StatusOK, do("PUT", "/class/updateClass?name=一班", `{"archived":true}`).Code)
+	assert.Equal(t, http.StatusConflict, do("POST", "/class/moveStudents?to=一班", `["001"]`).Code)
+	// 归档班级中已有的学生仍可通过导入更新，新学生不能导入到归档班级
+	mu.Lock()
+	require.NoError(t, store.Put(&student{Name: "王五", Class: "一班", Number: "003"}))
+	mu.Unlock()
+	content := "学号,姓名,班级\n003,王五五,一班\n004,赵六,一班\n"
+	report = importCSV(t, "?dryRun=true", content)
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, 3, report.Errors[0].Line)
+	report = importCSV(t, "", content)
+	assert.Equal(t, 1, report.Imported)
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, 3, report.Errors[0].Line)
+	assert.Contains(t, report.Errors[0].Msg, errClassArchived.Error())
+	assert.Equal(t, "王五五", students["003"].Name)
+	assert.Nil(t, students["004"])
+	rr = do("GET", "/class/listClasses", "")
+	assert.NotContains(t, rr.Body.String(), `"一班"`)
+	rr = do("GET", "/class/listClasses?archived=true", "")
+	assert.Contains(t, rr.Body.String(), `"一班"`)
+
+	// 班级写入文件，重新读取后仍然存在
+	require.NoError(t, loadClasses(path))
+	assert.Len(t, classes, 2)
+	assert.True(t, classes["一班"].Archived)
+}
+
+func TestTerms(t *testing.T) {
+	setStudents(map[string]*student{
+		"001": {Name: "张三", Class: "一班", Number: "001", Scores: map[string]grade{"数学": numGrade(90)}},
+	})
+	path := filepath.Join(t.TempDir(), "term.json")
+	currentTerm = "2026-Spring"
+	require.NoError(t, loadTerm(path))
+	defer func() {
+		currentTerm = defaultTerm(time.Now())
+		termFile = ""
+	}()
+	assert.Equal(t, "2026-Fall", defaultTerm(time.Date(2026, time.September, 1, 0, 0, 0, 0, time.Local)))
+	assert.Equal(t, "2025-Fall", defaultTerm(time.Date(2026, time.January, 10, 0, 0, 0, 0, time.Local)))
+
+	r := gin.Default()
+	r.POST("/student/addScore", addOrUpdateScore)
+	r.GET("/student/getScore", getScore)
+	r.DELETE("/student/deleteScore", deleteScore)
+	r.GET("/student/getStudent", getStudent)
+	r.POST("/term/rollover", rolloverTermHandler)
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// 其他学期的成绩不会覆盖当前学期
+	assert.Equal(t, http.StatusOK, do("POST", "/student/addScore?number=001&term=2025-Fall", `{"数学":70,"语文":60}`).Code)
+	assert.Equal(t, numGrade(90), students["001"].Scores["数学"])
+	assert.Equal(t, map[string]grade{"数学": numGrade(70), "语文": numGrade(60)}, students["001"].Terms["2025-Fall"])
+	rr := do("GET", "/student/getScore?number=001&lessonName=数学&term=2025-Fall", "")
+	assert.JSONEq(t, `{"code":200,"msg":"操作成功","data":70}`, rr.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/student/getScore?number=001&lessonName=语文", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addScore?number=001&term=秋季", `{"数学":70}`).Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/student/deleteScore?number=001&term=2025-Fall", `["语文"]`).Code)
+	assert.Equal(t, map[string]grade{"数学": numGrade(70)}, students["001"].Terms["2025-Fall"])
+
+	// 成绩单按学期先后排列
+	rr = do("GET", "/student/getStudent?number=001&transcript=true", "")
+	var resp struct {
+		Transcript []termScores `json:"transcript"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, []termScores{
+		{Term: "2025-Fall", Scores: map[string]grade{"数学": numGrade(70)}},
+		{Term: "2026-Spring", Scores: map[string]grade{"数学": numGrade(90)}},
+	}, resp.Transcript)
+
+	// 当前学期的成绩只能写在scores中
+	r.POST("/student/addStudent", addStudent)
+	r.PUT("/student/updateStudent", updateStudent)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addStudent", `{"number":"002","name":"李四","terms":{"2026-Spring":{"数学":80}}}`).Code)
+	assert.NotContains(t, students, "002")
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/student/updateStudent?number=001", `{"terms":{"2026-Spring":{"数学":80}}}`).Code)
+	assert.Equal(t, numGrade(90), students["001"].Scores["数学"])
+
+	// 切换学期后当前成绩归入原学期
+	rr = do("POST", "/term/rollover?to=2026-Fall", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Nil(t, students["001"].Scores)
+	assert.Equal(t, map[string]grade{"数学": numGrade(90)}, students["001"].Terms["2026-Spring"])
+	assert.Equal(t, http.StatusOK, do("POST", "/student/addScore?number=001", `{"数学":95}`).Code)
+	assert.Equal(t, map[string]grade{"数学": numGrade(95)}, students["001"].Scores)
+	currentTerm = ""
+	require.NoError(t, loadTerm(path))
+	assert.Equal(t, "2026-Fall", currentTerm)
+
+	// 保存学期失败时不切换学期，成绩保持不变
+	termFile = filepath.Join(t.TempDir(), "missing", "term.json")
+	rr = do("POST", "/term/rollover?to=2027-Spring", "")
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, "2026-Fall", currentTerm)
+	assert.Equal(t, map[string]grade{"数学": numGrade(95)}, students["001"].Scores)
+	assert.Nil(t, students["001"].Terms["2026-Fall"])
+
+	assert.Equal(t, "db/term.json", defaultTermFile("wal", "db"))
+	assert.Equal(t, "students.db.term.json", defaultTermFile("bolt", "students.db"))
+	assert.Equal(t, "", defaultTermFile("memory", "students.db"))
+}
+
+func TestGrade(t *testing.T) {
+	for text, expected := range map[string]grade{
+		"89.5": numGrade(89.5),
+		" 90 ": numGrade(90),
+		"a-":   {Mark: "A-"},
+		"合格":   {Mark: markPass},
+		"Fail": {Mark: markFail},
+		"缺考":   {Mark: markAbsent},
+		"免修":   {Mark: markExempt},
+		"作弊":   {Mark: markCheating},
+	} {
+		g, err := parseGrade(text)
+		require.NoError(t, err, text)
+		assert.Equal(t, expected, g, text)
+	}
+	for _, text := range []string{"优秀", "NaN", "inf", "-Infinity", "0x1p3", "1_0"} {
+		_, err := parseGrade(text)
+		assert.Error(t, err, text)
+	}
+	assert.Error(t, checkScore(nil, numGrade(math.NaN())))
+
+	// 数值成绩在JSON中仍然是数字
+	data, err := json.Marshal(map[string]grade{"数学": numGrade(89.5), "语文": numGrade(90), "体育": {Mark: markPass}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"数学":89.5,"语文":90,"体育":"合格"}`, string(data))
+	var scores map[string]grade
+	require.NoError(t, json.Unmarshal([]byte(`{"数学":89.5,"英语":"B+","体育":"absent"}`), &scores))
+	assert.Equal(t, map[string]grade{"数学": numGrade(89.5), "英语": {Mark: "B+"}, "体育": {Mark: markAbsent}}, scores)
+	assert.Error(t, json.Unmarshal([]byte(`{"数学":true}`), &scores))
+	assert.Error(t, json.Unmarshal([]byte(`{"数学":"NaN"}`), &scores))
+	assert.Error(t, json.Unmarshal([]byte(`{"数学":1e400}`), &scores))
+
+	// 课程满分只限制数值成绩
+	setStudents(map[string]*student{"001": {Name: "张三", Number: "001"}})
+	courses = map[string]*course{"MATH": {Code: "MATH", Name: "数学", MaxScore: 100, PassLine: 60}}
+	require.NoError(t, rebuildCourseLookup())
+	defer func() {
+		courses = make(map[string]*course)
+		courseLookup = make(map[string]string)
+	}()
+	r := gin.Default()
+	r.POST("/student/addScore", addOrUpdateScore)
+	r.GET("/student/getScore", getScore)
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{"数学":99.5}`, http.StatusOK},
+		{`{"数学":100.5}`, http.StatusBadRequest},
+		{`{"数学":"缺考"}`, http.StatusOK},
+		{`{"数学":"优秀"}`, http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest("POST", "/student/addScore?number=001", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, tc.code, rr.Code, tc.body)
+	}
+	req, _ := http.NewRequest("GET", "/student/getScore?number=001&lessonName=数学", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.JSONEq(t, `{"code":200,"msg":"操作成功","data":"缺考"}`, rr.Body.String())
+}
+
+func TestComponentScores(t *testing.T) {
+	setStudents(map[string]*student{"001": {Name: "张三", Class: "一班", Number: "001"}})
+	courses = make(map[string]*course)
+	courseLookup = make(map[string]string)
+	defer func() {
+		courses = make(map[string]*course)
+		courseLookup = make(map[string]string)
+	}()
+	defer os.RemoveAll(uploadDir)
+	defer os.RemoveAll(importingDir)
+
+	r := gin.Default()
+	r.POST("/course/addCourse", addCourse)
+	r.PUT("/course/updateCourse", updateCourse)
+	r.POST("/student/addScore", addOrUpdateScore)
+	r.DELETE("/student/deleteScore", deleteScore)
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// 课程没有注册时不能按考核项录入，否则会被当作一门独立的课程
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addScore?number=001&component=期中", `{"数学":80}`).Code)
+	assert.Empty(t, students["001"].Scores)
+
+	// 权重合计必须为100
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/course/addCourse", `{"code":"MATH","name":"数学","components":[{"name":"期中","weight":30},{"name":"期末","weight":50}]}`).Code)
+	rr := do("POST", "/course/addCourse", `{"code":"MATH","name":"数学","components":[{"name":"平时","weight":20},{"name":"期中","weight":30},{"name":"期末","weight":50}]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// 考核项不齐全时没有总评，齐全后自动计算
+	assert.Equal(t, http.StatusOK, do("POST", "/student/addScore?number=001", `{"数学.平时":90,"数学.期中":80}`).Code)
+	_, ok := students["001"].Scores["数学"]
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusOK, do("POST", "/student/addScore?number=001&component=期末", `{"数学":85.5}`).Code)
+	assert.Equal(t, numGrade(84.75), students["001"].Scores["数学"])
+	assert.Equal(t, numGrade(85.5), students["001"].Scores["数学.期末"])
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addScore?number=001", `{"数学.实验":90}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addScore?number=001&component=实验", `{"数学":90}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addScore?number=001&component=期中", `{"物理":90}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addScore?number=001", `{"数学.期中":"A"}`).Code)
+
+	// 缺考计0分，免考不计入
+	assert.Equal(t, http.StatusOK, do("POST", "/student/addScore?number=001", `{"数学.期中":"缺考"}`).Code)
+	assert.Equal(t, numGrade(60.75), students["001"].Scores["数学"])
+	assert.Equal(t, http.StatusOK, do("POST", "/student/addScore?number=001", `{"数学.期中":"免考"}`).Code)
+	assert.Equal(t, numGrade(86.79), students["001"].Scores["数学"])
+
+	// 总评由考核项计算，不能直接修改或单独删除
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/student/addScore?number=001", `{"数学":60}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("DELETE", "/student/deleteScore?number=001", `["数学"]`).Code)
+	assert.Equal(t, numGrade(86.79), students["001"].Scores["数学"])
+
+	// 删除考核项后总评随之删除
+	assert.Equal(t, http.StatusOK, do("DELETE", "/student/deleteScore?number=001", `["数学.平时"]`).Code)
+	_, ok = students["001"].Scores["数学"]
+	assert.False(t, ok)
+
+	// 修改权重后重新计算已有学生的总评
+	assert.Equal(t, http.StatusOK, do("POST", "/student/addScore?number=001", `{"数学.平时":90,"数学.期中":80}`).Code)
+	rr = do("PUT", "/course/updateCourse?code=MATH", `{"name":"数学","components":[{"name":"平时","weight":50},{"name":"期中","weight":25},{"name":"期末","weight":25}]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, numGrade(86.38), students["001"].Scores["数学"])
+
+	// 导入时宽表可以按考核项分列
+	report := importCSV(t, "?layout=wide", "学号,姓名,数学.平时,数学.期中,数学.期末\n002,李四,100,80,60\n")
+	require.Empty(t, report.Errors)
+	assert.Equal(t, numGrade(85), students["002"].Scores["数学"])
+	assert.Equal(t, numGrade(100), students["002"].Scores["数学.平时"])
+
+	// 已有成绩的考核项不能删除，没有成绩的可以删除
+	rr = do("PUT", "/course/updateCourse?code=MATH", `{"name":"数学"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+	rr = do("PUT", "/course/updateCourse?code=MATH", `{"name":"数学","components":[{"name":"平时","weight":50},{"name":"期中","weight":25},{"name":"期末","weight":15},{"name":"实验","weight":10}]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rr = do("PUT", "/course/updateCourse?code=MATH", `{"name":"数学","components":[{"name":"平时","weight":50},{"name":"期中","weight":25},{"name":"期末","weight":25}]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, numGrade(86.38), students["001"].Scores["数学"])
+
+	// 总评和全部考核项一起删除
+	rr = do("DELETE", "/student/deleteScore?number=002", `["数学","数学.平时","数学.期中","数学.期末"]`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Empty(t, students["002"].Scores)
+
+	// 只有已注册课程的考核项才不计入总分，名称中带分隔符的课程照常计入
+	assert.True(t, isComponentKey("数学.期中"))
+	assert.False(t, isComponentKey("数学.实验"))
+	assert.False(t, isComponentKey("Python3.0"))
+	assert.Equal(t, 175.0, sumScores(map[string]grade{"数学": numGrade(85), "数学.期中": numGrade(80), "Python3.0": numGrade(90)}))
+	rr = do("POST", "/course/addCourse", `{"code":"PY","name":"Python3.0","components":[{"name":"上机","weight":100}]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.False(t, isComponentKey("Python3.0"))
+	assert.True(t, isComponentKey("Python3.0.上机"))
+}
+
+func TestTranscriptGPA(t *testing.T) {
+	currentTerm = "2026-Spring"
+	defer func() { currentTerm = defaultTerm(time.Now()) }()
+	courses = map[string]*course{
+		"MATH": {Code: "MATH", Name: "数学", Credits: 4, MaxScore: 150, PassLine: 90},
+		"PE":   {Code: "PE", Name: "体育", Credits: 1, MaxScore: 100, PassLine: 60},
+		"ENG":  {Code: "ENG", Name: "英语", Credits: 3, MaxScore: 100, PassLine: 60},
+	}
+	require.NoError(t, rebuildCourseLookup())
+	defer func() {
+		courses = make(map[string]*course)
+		courseLookup = make(map[string]string)
+	}()
+	setStudents(map[string]*student{
+		"001": {Name: "张三", Number: "001",
+			Scores: map[string]grade{"数学": numGrade(120), "体育": {Mark: markPass}, "英语": {Mark: "B+"}},
+			Terms: map[string]map[string]grade{
+				"2025-Fall": {"数学": numGrade(135), "英语": {Mark: markAbsent}, "美术": numGrade(80)},
+			}},
+	})
+	r := gin.Default()
+	r.GET("/student/transcript", getTranscript)
+	type response struct {
+		Data struct {
+			Terms      []termTranscript `json:"terms"`
+			Cumulative gpaSummary       `json:"cumulative"`
+		} `json:"data"`
+	}
+	get := func(query string) response {
+		req, _ := http.NewRequest("GET", "/student/transcript"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp response
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := get("?number=001")
+	require.Len(t, resp.Data.Terms, 2)
+	fall, spring := resp.Data.Terms[0], resp.Data.Terms[1]
+	assert.Equal(t, "2025-Fall", fall.Term)
+	// 数学135/150=90%为4.0，英语缺考为0，美术未登记按1学分80分为3.0
+	require.NotNil(t, fall.GPA)
+	assert.Equal(t, 2.38, *fall.GPA)
+	assert.Equal(t, 8.0, fall.TotalCredits)
+	assert.Equal(t, 5.0, fall.EarnedCredits)
+	assert.Equal(t, 1, fall.Failed)
+	// 数学120/150=80%为3.0，英语B+为3.3，体育合格不计入绩点
+	require.NotNil(t, spring.GPA)
+	assert.Equal(t, 3.13, *spring.GPA)
+	assert.Equal(t, 7.0, spring.GPACredits)
+	assert.Equal(t, 8.0, spring.EarnedCredits)
+	assert.Nil(t, spring.Courses[0].Points)
+	assert.Equal(t, "体育", spring.Courses[0].Subject)
+	require.NotNil(t, resp.Data.Cumulative.GPA)
+	assert.Equal(t, 2.73, *resp.Data.Cumulative.GPA)
+	assert.Equal(t, 5, resp.Data.Cumulative.Passed)
+	assert.Equal(t, 1, resp.Data.Cumulative.Failed)
+
+	// 5.0制按“得分率/10-5”线性换算
+	resp = get("?number=001&scale=5.0")
+	assert.Equal(t, 3.51, *resp.Data.Terms[1].GPA)
+
+	// 自定义绩点规则
+	path := filepath.Join(t.TempDir(), "scales.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name":"pf","max":1,"bands":[{"min":0,"points":0},{"min":60,"points":1}],"letters":{"B+":1}}]`), 0644))
+	require.NoError(t, loadGPAScales(path))
+	defer delete(gpaScales, "pf")
+	resp = get("?number=001&scale=pf")
+	assert.Equal(t, 1.0, *resp.Data.Terms[1].GPA)
+	for _, bad := range []string{
+		`[{"name":"zero","max":0,"bands":[{"min":0,"points":0}],"letters":{"A":1}}]`,
+		`[{"name":"noletters","max":4,"bands":[{"min":0,"points":0}]}]`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(bad), 0644))
+		assert.Error(t, loadGPAScales(path), bad)
+	}
+
+	// 注册时没有填写学分的课程与未注册的课程一样按默认学分计算，明确填写0的课程不计学分
+	var c course
+	require.NoError(t, json.Unmarshal([]byte(`{"code":"ART","name":"美术"}`), &c))
+	require.NoError(t, c.validate())
+	assert.Equal(t, defaultCredits, c.Credits)
+	require.NoError(t, json.Unmarshal([]byte(`{"code":"HR","name":"班会","credits":0}`), &c))
+	require.NoError(t, c.validate())
+	assert.Equal(t, 0.0, c.Credits)
+
+	for _, query := range []string{"?number=001&scale=3.0", "?number=009"} {
+		req, _ := http.NewRequest("GET", "/student/transcript"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.NotEqual(t, http.StatusOK, rr.Code, query)
+	}
+}
+
+func TestStatistics(t *testing.T) {
+	setStudents(map[string]*student{
+		"001": {Name: "张三", Class: "一班", Number: "001", Scores: map[string]grade{"数学": numGrade(95), "语文": numGrade(70)}},
+		"002": {Name: "李四", Class: "一班", Number: "002", Scores: map[string]grade{"数学": numGrade(55)}},
+		"003": {Name: "王五", Class: "一班", Number: "003", Scores: map[string]grade{"数学": numGrade(80), "数学.期中": numGrade(10)}},
+		"004": {Name: "赵六", Class: "二班", Number: "004", Scores: map[string]grade{"数学": numGrade(100)}},
+		"005": {Name: "钱七", Class: "二班", Number: "005", Scores: map[string]grade{"数学": {Mark: markAbsent}}},
+	})
+	courses = map[string]*course{
+		"MATH": {Code: "MATH", Name: "数学", MaxScore: 100, PassLine: 60, Components: []component{{Name: "期中", Weight: 40}, {Name: "期末", Weight: 60}}},
+		"CHN":  {Code: "CHN", Name: "语文", MaxScore: 100, PassLine: 60},
+	}
+	require.NoError(t, rebuildCourseLookup())
+	defer func() {
+		courses = make(map[string]*course)
+		courseLookup = make(map[string]string)
+	}()
+	r := gin.Default()
+	r.GET("/report/statistics", getStatistics)
+	get := func(query string) []subjectStats {
+		req, _ := http.NewRequest("GET", "/report/statistics"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp struct {
+			Data struct {
+				Stats []subjectStats `json:"stats"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp.Data.Stats
+	}
+
+	stats := get("?subject=数学&bucket=20")
+	require.Len(t, stats, 3)
+	one, two, all := stats[0], stats[1], stats[2]
+	assert.Equal(t, "一班", one.Class)
+	assert.Equal(t, 3, one.Count)
+	assert.Equal(t, 76.67, one.Average)
+	assert.Equal(t, 80.0, one.Median)
+	assert.Equal(t, 16.5, one.StdDev)
+	assert.Equal(t, 55.0, one.Min)
+	assert.Equal(t, 95.0, one.Max)
+	assert.Equal(t, 66.67, one.PassRate)
+	assert.Equal(t, 33.33, one.ExcellentRate)
+	assert.Equal(t, []histogramBucket{
+		{Min: 0, Max: 20}, {Min: 20, Max: 40}, {Min: 40, Max: 60, Count: 1}, {Min: 60, Max: 80}, {Min: 80, Max: 100, Count: 2},
+	}, one.Histogram)
+	// 缺考不参与平均分，但计入及格率的分母
+	assert.Equal(t, "二班", two.Class)
+	assert.Equal(t, 1, two.Absent)
+	assert.Equal(t, 50.0, two.PassRate)
+	assert.Equal(t, 100, int(two.Histogram[4].Max))
+	assert.Equal(t, 1, two.Histogram[4].Count)
+	assert.Equal(t, scopeCohort, all.Scope)
+	assert.Equal(t, 4, all.Count)
+	assert.Equal(t, 82.5, all.Average)
+	assert.Equal(t, 87.5, all.Median)
+	assert.Equal(t, 60.0, all.PassRate)
+
+	// 按班级统计全部课程，考核项不单独统计
+	stats = get("?class=一班")
+	require.Len(t, stats, 4)
+	assert.Equal(t, "数学", stats[0].Subject)
+	assert.Equal(t, "语文", stats[2].Subject)
+	assert.Equal(t, 70.0, stats[3].Average)
+
+	// 分数段过窄或参数不是有限的数字时拒绝统计
+	for _, query := range []string{"?bucket=0.5", "?bucket=NaN", "?bucket=Inf", "?excellent=NaN"} {
+		req, _ := http.NewRequest("GET", "/report/statistics"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+	assert.Len(t, get("?subject=数学&bucket=1")[0].Histogram, 100)
+}
+
+func TestRanking(t *testing.T) {
+	setStudents(map[string]*student{
+		"001": {Name: "张三", Class: "一班", Number: "001", Scores: map[string]grade{"数学": numGrade(90), "语文": numGrade(80)}},
+		"002": {Name: "李四", Class: "一班", Number: "002", Scores: map[string]grade{"数学": numGrade(95), "语文": numGrade(75)}},
+		"003": {Name: "王五", Class: "一班", Number: "003", Scores: map[string]grade{"数学": numGrade(80), "语文": numGrade(90)}},
+		"004": {Name: "赵六", Class: "二班", Number: "004", Scores: map[string]grade{"数学": numGrade(100), "语文": numGrade(60)}},
+		"005": {Name: "钱七", Class: "二班", Number: "005", Scores: map[string]grade{"数学": {Mark: markAbsent}, "语文": numGrade(90)}},
+	})
+	r := gin.Default()
+	r.GET("/report/ranking", getRanking)
+	type response struct {
+		Data struct {
+			Total   int         `json:"total"`
+			Ranking []rankEntry `json:"ranking"`
+		} `json:"data"`
+	}
+	get := func(query string) response {
+		req, _ := http.NewRequest("GET", "/report/ranking"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var resp response
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+	ranks := func(resp response) []string {
+		var list []string
+		for _, e := range resp.Data.Ranking {
+			list = append(list, e.Number+":"+strconv.Itoa(e.Rank))
+		}
+		return list
+	}
+
+	// 按总分排名，张三、李四、王五并列，缺考不计分
+	resp := get("")
+	assert.Equal(t, []string{"001:1", "002:1", "003:1", "004:4", "005:5"}, ranks(resp))
+	assert.Equal(t, []float64{50, 50, 50, 25, 0}, []float64{resp.Data.Ranking[0].Percentile, resp.Data.Ranking[1].Percentile, resp.Data.Ranking[2].Percentile, resp.Data.Ranking[3].Percentile, resp.Data.Ranking[4].Percentile})
+	assert.Equal(t, []string{"001:1", "002:1", "003:1", "004:2", "005:3"}, ranks(get("?method=dense")))
+
+	// 按单科在班级内排名，没有数值成绩的学生不参与
+	resp = get("?subject=数学&class=二班")
+	assert.Equal(t, []string{"004:1"}, ranks(resp))
+	assert.Equal(t, 100.0, resp.Data.Ranking[0].Percentile)
+	assert.Equal(t, []string{"004:1", "002:2", "001:3", "003:4"}, ranks(get("?subject=数学")))
+
+	// 查询单个学生的名次
+	resp = get("?subject=语文&number=001")
+	assert.Equal(t, 5, resp.Data.Total)
+	assert.Equal(t, []string{"001:3"}, ranks(resp))
+	assert.Equal(t, 50.0, resp.Data.Ranking[0].Percentile)
+
+	req, _ := http.NewRequest("GET", "/report/ranking?method=fractional", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// 小数成绩的总分与累加顺序无关，相同的总分并列
+	setStudents(map[string]*student{
+		"001": {Name: "张三", Class: "一班", Number: "001", Scores: map[string]grade{"化学": numGrade(0.1), "物理": numGrade(0.2), "生物": numGrade(0.3)}},
+		"002": {Name: "李四", Class: "一班", Number: "002", Scores: map[string]grade{"化学": numGrade(0.3), "物理": numGrade(0.2), "生物": numGrade(0.1)}},
+	})
+	for i := 0; i < 5; i++ {
+		resp = get("")
+		assert.Equal(t, []string{"001:1", "002:1"}, ranks(resp))
+		assert.Equal(t, 0.6, resp.Data.Ranking[0].Score)
+	}
+}
+
+// failingStore 写入总是失败的存储，用于测试存储出错时的处理
+type failingStore struct {
+	studentStore
+}
+
+func (failingStore) Put(*student) error {
+	return errors.New("存储不可用")
+}
+
+func (failingStore) PutAll([]*student) error {
+	return errors.New("存储不可用")
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 上传文件的类型
+const (
+	uploadCSV  = "csv"
+	uploadXLSX = "xlsx"
+)
+
+var maxUploadSize int64 = 10 << 20 //单个上传文件的最大字节数，启动时可通过参数修改
+
+var (
+	errUploadTooLarge  = errors.New("文件超过大小限制")
+	errUploadType      = errors.New("仅支持CSV或XLSX文件")
+	errUploadNotExists = errors.New("上传文件不存在")
+)
+
+// limitedReader 读取超过limit字节时返回errUploadTooLarge，而不是像io.LimitReader那样静默截断
+type limitedReader struct {
+	r     io.Reader
+	n     int64 //剩余可读的字节数
+	limit int64
+}
+
+func newLimitedReader(r io.Reader, limit int64) *limitedReader {
+	return &limitedReader{r: r, n: limit, limit: limit}
+}
+
+// exceeded 是否已经读到超过限制的内容
+func (l *limitedReader) exceeded() bool {
+	return l.n < 0
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, fmt.Errorf("%w：不能超过%v字节", errUploadTooLarge, l.limit)
+	}
+	//多读一个字节用于判断是否超过限制
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n + int(l.n), fmt.Errorf("%w：不能超过%v字节", errUploadTooLarge, l.limit)
+	}
+	return n, err
+}
+
+// uploadMeta 待导入的上传文件，数据保存在上传目录下以ID命名的文件中，元数据保存在同名的.json文件中
+// 直接放入上传目录、没有元数据的文件也视为待导入文件，ID即文件名
+type uploadMeta struct {
+	ID         string    `json:"id"`
+	FileName   string    `json:"fileName"` //客户端提供的原始文件名，只用于展示和错误报告
+	Size       int64     `json:"size"`
+	Type       string    `json:"type"`               //csv 或 xlsx
+	Encoding   string    `json:"encoding,omitempty"` //csv文件的编码：utf-8、utf-8-bom 或 gb18030
+	UploadedAt time.Time `json:"uploadedAt"`
+	path       string    //数据文件当前所在路径
+}
+
+// sniffUpload 根据文件开头的内容判断类型和编码，不信任客户端提供的扩展名和Content-Type
+// 编码只根据开头判断，完整的文件应再用encodingDetector确认
+func sniffUpload(head []byte) (fileType, encoding string, err error) {
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return uploadXLSX, "", nil
+	}
+	if !strings.HasPrefix(http.DetectContentType(head), "text/") {
+		return "", "", errUploadType
+	}
+	return uploadCSV, detectEncoding(head), nil
+}
+
+// saveUpload 以服务端生成的ID保存上传文件，超过大小限制或类型不支持时不保留任何数据
+func saveUpload(fileName string, r io.Reader) (uploadMeta, error) {
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return uploadMeta{}, err
+	}
+	meta := uploadMeta{
+		ID:         newID(),
+		FileName:   filepath.Base(fileName),
+		UploadedAt: time.Now(),
+	}
+	meta.path = filepath.Join(uploadDir, meta.ID)
+	//先写入临时文件，校验通过后再改名，避免导入任务读到不完整的文件
+	tmpPath := meta.path + ".tmp"
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return uploadMeta{}, err
+	}
+	defer os.Remove(tmpPath)
+	head := make([]byte, 4096)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		dst.Close()
+		return uploadMeta{}, err
+	}
+	head = head[:n]
+	meta.Type, meta.Encoding, err = sniffUpload(head)
+	if err != nil {
+		dst.Close()
+		return uploadMeta{}, err
+	}
+	//保存的同时检查整个文件的编码
+	var detector encodingDetector
+	out := io.MultiWriter(dst, &detector)
+	if _, err := out.Write(head); err != nil {
+		dst.Close()
+		return uploadMeta{}, err
+	}
+	//多读一个字节用于判断是否超过限制
+	copied, err := io.Copy(out, io.LimitReader(r, maxUploadSize-int64(n)+1))
+	closeErr := dst.Close()
+	if err != nil {
+		return uploadMeta{}, err
+	}
+	if closeErr != nil {
+		return uploadMeta{}, closeErr
+	}
+	meta.Size = int64(n) + copied
+	if meta.Size > maxUploadSize {
+		return uploadMeta{}, errUploadTooLarge
+	}
+	if meta.Type == uploadCSV {
+		meta.Encoding = detector.result(false)
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return uploadMeta{}, err
+	}
+	if err := os.WriteFile(meta.path+".json", data, 0644); err != nil {
+		return uploadMeta{}, err
+	}
+	if err := os.Rename(tmpPath, meta.path); err != nil {
+		os.Remove(meta.path + ".json")
+		return uploadMeta{}, err
+	}
+	return meta, nil
+}
+
+// loadUpload 读取dir下名为name的数据文件的元数据，没有元数据时根据内容推断
+func loadUpload(dir, name string) (uploadMeta, error) {
+	path := filepath.Join(dir, name)
+	meta := uploadMeta{ID: name, FileName: name}
+	data, err := os.ReadFile(path + ".json")
+	if err == nil {
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return uploadMeta{}, err
+		}
+		meta.path = path
+		return meta, nil
+	}
+	if !os.IsNotExist(err) {
+		return uploadMeta{}, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return uploadMeta{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return uploadMeta{}, err
+	}
+	head := make([]byte, 4096)
+	n, _ := io.ReadFull(file, head)
+	meta.Type, meta.Encoding, err = sniffUpload(head[:n])
+	if err != nil {
+		return uploadMeta{}, fmt.Errorf("%v：%w", name, err)
+	}
+	if meta.Type == uploadCSV {
+		meta.Encoding, err = detectFileEncoding(path)
+		if err != nil {
+			return uploadMeta{}, err
+		}
+	}
+	meta.Size = info.Size()
+	meta.UploadedAt = info.ModTime()
+	meta.path = path
+	return meta, nil
+}
+
+// listUploads 返回上传目录中的待导入文件，ids不为空时只返回其中指定的文件
+func listUploads(ids []string) ([]uploadMeta, error) {
+	dir, err := os.ReadDir(uploadDir)
+	if os.IsNotExist(err) && len(ids) == 0 {
+		return []uploadMeta{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	uploads := make([]uploadMeta, 0, len(dir))
+	for _, file := range dir {
+		name := file.Name()
+		if !isUploadFile(file) {
+			continue
+		}
+		if len(ids) > 0 && !wanted[name] {
+			continue
+		}
+		meta, err := loadUpload(uploadDir, name)
+		if os.IsNotExist(err) {
+			continue //已被其他任务认领
+		}
+		if err != nil {
+			return nil, err
+		}
+		delete(wanted, name)
+		uploads = append(uploads, meta)
+	}
+	for id := range wanted {
+		return nil, fmt.Errorf("%w：%v", errUploadNotExists, id)
+	}
+	sort.Slice(uploads, func(a, b int) bool {
+		return uploads[a].UploadedAt.Before(uploads[b].UploadedAt)
+	})
+	return uploads, nil
+}
+
+// isUploadFile 判断上传目录中的一项是否为待导入的数据文件，而不是元数据或正在写入的临时文件
+func isUploadFile(file os.DirEntry) bool {
+	name := file.Name()
+	return !file.IsDir() && !strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".tmp")
+}
+
+// claimUploads 把待导入文件及其元数据移动到任务自己的目录下，避免被其他任务重复读取
+func claimUploads(jobID string, ids []string) ([]uploadMeta, error) {
+	uploads, err := listUploads(ids)
+	if err != nil {
+		return nil, err
+	}
+	jobDir := filepath.Join(importingDir, jobID)
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		return nil, err
+	}
+	claimed := make([]uploadMeta, 0, len(uploads))
+	for _, meta := range uploads {
+		path := filepath.Join(jobDir, meta.ID)
+		err := os.Rename(meta.path, path)
+		if os.IsNotExist(err) {
+			continue //已被其他任务认领
+		}
+		if err != nil {
+			return nil, err
+		}
+		//元数据随文件一起移动，导入回滚时可以原样放回
+		if err := os.Rename(meta.path+".json", path+".json"); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		meta.path = path
+		claimed = append(claimed, meta)
+	}
+	return claimed, nil
+}
+
+// releaseUploads 把认领的文件及其元数据放回上传目录，先放回元数据，避免被当作没有元数据的文件列出
+func releaseUploads(uploads []uploadMeta) error {
+	for _, meta := range uploads {
+		path := filepath.Join(uploadDir, meta.ID)
+		if err := os.Rename(meta.path+".json", path+".json"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Rename(meta.path, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadIDPattern 上传ID的格式，与newID生成的16位十六进制一致
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// validUploadID 检查ID是生成的格式，或者是列表中列出的没有元数据的文件名，防止路径穿越或操作.json、.tmp等元数据文件
+func validUploadID(id string) bool {
+	if uploadIDPattern.MatchString(id) {
+		return true
+	}
+	dir, err := os.ReadDir(uploadDir)
+	if err != nil {
+		return false
+	}
+	for _, file := range dir {
+		if file.Name() == id {
+			return isUploadFile(file)
+		}
+	}
+	return false
+}
+
+func postFile(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize+1<<20)
+	// 确保请求中有文件上传
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有读取到文件"})
+		return
+	}
+	defer file.Close()
+	meta, err := saveUpload(header.Filename, file)
+	switch {
+	case errors.Is(err, errUploadTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("文件不能超过%v字节", maxUploadSize)})
+		return
+	case errors.Is(err, errUploadType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "上传失败"})
+		return
+	}
+	// 返回成功响应
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "上传成功",
+		"data": meta})
+}
+
+// getUploads 列出等待导入的上传文件
+func getUploads(c *gin.Context) {
+	uploads, err := listUploads(nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": uploads})
+}
+
+// deleteUpload 删除一个等待导入的上传文件
+func deleteUpload(c *gin.Context) {
+	id := c.Param("id")
+	if !validUploadID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "上传ID不合法"})
+		return
+	}
+	path := filepath.Join(uploadDir, id)
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": errUploadNotExists.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	os.Remove(path + ".json")
+	c.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"msg":  "操作成功",
+		"data": ""})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"os"
+	"strings"
+)
+
+// parseXLSXFile 读取XLSX文件，把解析出的学生交给工作协程
+func (j *importJob) parseXLSXFile(filePath, name string) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		j.errorChan <- ParseError{File: name, Msg: fmt.Sprintf("无法打开文件：%v", err)}
+		return
+	}
+	defer file.Close()
+	j.parseXLSX(name, file)
+}
+
+// parseXLSX 依次解析每个工作表，每个工作表单独识别表头，行号与Excel中显示的一致
+func (j *importJob) parseXLSX(name string, r io.Reader) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		j.errorChan <- ParseError{File: name, Msg: fmt.Sprintf("无法读取XLSX文件：%v", err)}
+		return
+	}
+	defer f.Close()
+	for _, sheet := range f.GetSheetList() {
+		rows, err := f.Rows(sheet)
+		if err != nil {
+			j.errorChan <- ParseError{File: name, Sheet: sheet, Msg: fmt.Sprintf("读取工作表失败：%v", err)}
+			continue
+		}
+		line := 0
+		j.parseRows(name, sheet, func() ([]string, int, error) {
+			for rows.Next() {
+				line++
+				//读取原始值，避免学号等数字被格式化为科学计数法
+				record, err := rows.Columns(excelize.Options{RawCellValue: true})
+				if err != nil {
+					return nil, line, &ParseError{Line: line, Msg: fmt.Sprintf("读取数据有误：%v", err)}
+				}
+				if !emptyRecord(record) {
+					return record, line, nil
+				}
+			}
+			if err := rows.Error(); err != nil {
+				return nil, 0, err
+			}
+			return nil, 0, io.EOF
+		})
+		rows.Close()
+	}
+}
+
+// emptyRecord 判断一行是否全部为空白，XLSX中的空行不计入读取行数
+func emptyRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}